@@ -1,20 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 )
 
+const apiShutdownTimeout = 10 * time.Second
+
 type Response struct {
-	Success        bool      `json:"success"`
-	Error          string    `json:"error,omitempty"`
-	User           *User     `json:"user,omitempty"`
-	Users          []User    `json:"users,omitempty"`
-	IPCheckHistory []IPCheck `json:"ip_check_history,omitempty"`
+	Success        bool             `json:"success"`
+	Error          string           `json:"error,omitempty"`
+	User           *User            `json:"user,omitempty"`
+	Users          []User           `json:"users,omitempty"`
+	IPCheckHistory []IPCheck        `json:"ip_check_history,omitempty"`
+	IPCheckDiff    *IPCheckDiff     `json:"ip_check_diff,omitempty"`
+	GeoHealth      []ProviderHealth `json:"geo_health,omitempty"`
 }
 
 func (resp *Response) toJSON() ([]byte, error) {
@@ -48,6 +57,20 @@ func queryCheckMiddleware(r *mux.Router) mux.MiddlewareFunc {
 					return
 				}
 
+			case "/get_history_diff":
+				for _, param := range []string{"fromID", "toID"} {
+					err := idCheck(req.URL.Query().Get(param), param)
+					if err != nil {
+						badResp, _ := NewErrorResponse(err).toJSON()
+						w.WriteHeader(http.StatusBadRequest)
+						_, err = w.Write(badResp)
+						if err != nil {
+							log.Error(err)
+						}
+						return
+					}
+				}
+
 			case "/delete_history_record":
 				err := idCheck(req.URL.Query().Get("ipCheckID"), "ipCheckID")
 				if err != nil {
@@ -79,14 +102,63 @@ func idCheck(strID string, paramName string) error {
 	return nil
 }
 
-func API(env *Env) {
+func handlerGetGeoHealth(geo GeoProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := Response{
+			Success:   true,
+			GeoHealth: geoProviderHealth(geo),
+		}
+
+		respByte, err := resp.toJSON()
+		if err != nil {
+			log.Error(err)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(respByte)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func API(ctx context.Context, env *Env) {
 	r := mux.NewRouter()
 	r.Use(queryCheckMiddleware(r))
 	r.HandleFunc("/get_users", env.users.HandlerGetUsers).Methods(http.MethodGet)
 	r.HandleFunc("/get_user", env.users.HandlerGetUser).Methods(http.MethodGet)
 	r.HandleFunc("/get_history_by_tg", env.ipChecks.HandlerGetHistory).Methods(http.MethodGet)
+	r.HandleFunc("/get_history_diff", env.ipChecks.HandlerGetHistoryDiff).Methods(http.MethodGet)
 	r.HandleFunc("/delete_history_record", env.ipChecks.HandlerDeleteHistoryRecord).Methods(http.MethodDelete)
+	r.HandleFunc("/get_geo_health", handlerGetGeoHealth(env.geo)).Methods(http.MethodGet)
+	if env.webhook != nil {
+		r.Handle(env.webhook.Path(), env.webhook).Methods(http.MethodPost)
+	}
 
-	fmt.Println("starting server at :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	server := &http.Server{
+		Addr:      ":8080",
+		Handler:   r,
+		TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	// Telegram delivers webhook updates over HTTPS only, TLS may be terminated here or by a proxy
+	var err error
+	certFile, keyFile := os.Getenv("API_TLS_CERT"), os.Getenv("API_TLS_KEY")
+	if certFile != "" && keyFile != "" {
+		fmt.Println("starting TLS server at :8080")
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		fmt.Println("starting server at :8080")
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
 }
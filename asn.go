@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/oschwald/maxminddb-golang"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type ASNRecord struct {
+	ASN    int
+	Org    string
+	Prefix string
+}
+
+type asnRange struct {
+	start  net.IP
+	end    net.IP
+	asn    int
+	org    string
+	prefix string
+}
+
+// ASNDatabase keeps ip-to-ASN ranges from an iptoasn TSV file or a GeoLite2-ASN mmdb file in memory
+type ASNDatabase struct {
+	Path string
+
+	mu     sync.RWMutex
+	ranges []asnRange
+}
+
+func NewASNDatabase(path string) (*ASNDatabase, error) {
+	db := &ASNDatabase{Path: path}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *ASNDatabase) Reload() error {
+	var ranges []asnRange
+	var err error
+	if strings.HasSuffix(db.Path, ".mmdb") {
+		ranges, err = loadASNMMDB(db.Path)
+	} else {
+		ranges, err = loadASNTSV(db.Path)
+	}
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].start, ranges[j].start) < 0
+	})
+
+	db.mu.Lock()
+	db.ranges = ranges
+	db.mu.Unlock()
+	return nil
+}
+
+func (db *ASNDatabase) Lookup(ip net.IP) *ASNRecord {
+	ip = ip.To16()
+	if ip == nil {
+		return nil
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip, db.ranges[i].end) > 0 {
+		return nil
+	}
+
+	r := db.ranges[i]
+	record := &ASNRecord{ASN: r.asn, Org: r.org, Prefix: r.prefix}
+	if record.Prefix == "" {
+		record.Prefix = rangeCIDR(r.start, r.end, ip).String()
+	}
+	return record
+}
+
+// rangeCIDR returns the largest CIDR block containing ip which fits into [start, end]
+func rangeCIDR(start, end, ip net.IP) *net.IPNet {
+	bits := 128
+	if ip.To4() != nil {
+		ip, start, end, bits = ip.To4(), start.To4(), end.To4(), 32
+	}
+	for ones := 0; ones < bits; ones++ {
+		mask := net.CIDRMask(ones, bits)
+		network := ip.Mask(mask)
+		last := make(net.IP, len(network))
+		for i := range network {
+			last[i] = network[i] | ^mask[i]
+		}
+		if bytes.Compare(network, start) >= 0 && bytes.Compare(last, end) <= 0 {
+			return &net.IPNet{IP: network, Mask: mask}
+		}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// loadASNTSV reads the iptoasn.com format: range_start, range_end, AS_number, country_code, AS_description
+func loadASNTSV(path string) ([]asnRange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	ranges := make([]asnRange, 0, 1024)
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		asn, err := strconv.Atoi(fields[2])
+		if start == nil || end == nil || err != nil {
+			return nil, fmt.Errorf("%v:%v: invalid ASN record", path, line)
+		}
+		// AS 0 marks ranges which aren't routed
+		if asn == 0 {
+			continue
+		}
+		ranges = append(ranges, asnRange{start: start.To16(), end: end.To16(), asn: asn, org: fields[4]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+func loadASNMMDB(path string) ([]asnRange, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// IPv6 databases also alias IPv4 space under these prefixes, skip the copies
+	aliases := make([]*net.IPNet, 0, 3)
+	for _, cidr := range []string{"::ffff:0:0/96", "2001::/32", "2002::/16"} {
+		_, alias, _ := net.ParseCIDR(cidr)
+		aliases = append(aliases, alias)
+	}
+
+	ranges := make([]asnRange, 0, 1024)
+	networks := reader.Networks()
+NetworkLoop:
+	for networks.Next() {
+		record := struct {
+			ASN int    `maxminddb:"autonomous_system_number"`
+			Org string `maxminddb:"autonomous_system_organization"`
+		}{}
+		network, err := networks.Network(&record)
+		if err != nil {
+			return nil, err
+		}
+		if record.ASN == 0 {
+			continue
+		}
+
+		ones, bits := network.Mask.Size()
+		if bits == 128 {
+			for _, alias := range aliases {
+				if alias.Contains(network.IP) {
+					continue NetworkLoop
+				}
+			}
+			// IPv4 space of IPv6 databases lives in ::/96
+			if ones >= 96 && network.IP.Mask(net.CIDRMask(96, 128)).Equal(net.IPv6zero) {
+				network = &net.IPNet{IP: net.IP(network.IP[12:]), Mask: net.CIDRMask(ones-96, 32)}
+			}
+		}
+
+		last := make(net.IP, len(network.IP))
+		for i := range network.IP {
+			last[i] = network.IP[i] | ^network.Mask[i]
+		}
+		ranges = append(ranges, asnRange{
+			start:  network.IP.To16(),
+			end:    last.To16(),
+			asn:    record.ASN,
+			org:    record.Org,
+			prefix: network.String(),
+		})
+	}
+	if err := networks.Err(); err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
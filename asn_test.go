@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const asnTestTSV = "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+	"1.0.1.0\t1.0.3.255\t0\tNone\tNot routed\n" +
+	"1.0.4.0\t1.0.7.255\t38803\tAU\tWPL-AS-AP Wirefreebroadband Pty Ltd\n" +
+	"2001:db8::\t2001:db8:ffff:ffff:ffff:ffff:ffff:ffff\t64500\tZZ\tEXAMPLE-V6\n"
+
+func TestASNDatabaseLookup(t *testing.T) {
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "ip2asn-combined.tsv")
+	if err := os.WriteFile(plainPath, []byte(asnTestTSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gzPath := filepath.Join(dir, "ip2asn-combined.tsv.gz")
+	file, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzWriter := gzip.NewWriter(file)
+	if _, err := gzWriter.Write([]byte(asnTestTSV)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want *ASNRecord
+	}{
+		{ip: "1.0.0.1", want: &ASNRecord{ASN: 13335, Org: "CLOUDFLARENET", Prefix: "1.0.0.0/24"}},
+		{ip: "1.0.5.1", want: &ASNRecord{ASN: 38803, Org: "WPL-AS-AP Wirefreebroadband Pty Ltd", Prefix: "1.0.4.0/22"}},
+		{ip: "2001:db8::1", want: &ASNRecord{ASN: 64500, Org: "EXAMPLE-V6", Prefix: "2001:db8::/32"}},
+		// AS 0 ranges are skipped
+		{ip: "1.0.2.1"},
+		{ip: "0.255.255.255"},
+		{ip: "1.0.8.1"},
+		{ip: "2001:db9::1"},
+	}
+
+	for _, path := range []string{plainPath, gzPath} {
+		db, err := NewASNDatabase(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(db.ranges) != 3 {
+			t.Errorf("%v: loaded %v ranges, want 3", filepath.Base(path), len(db.ranges))
+		}
+		for _, tt := range tests {
+			got := db.Lookup(net.ParseIP(tt.ip))
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("%v: Lookup(%v) = %+v, want %+v", filepath.Base(path), tt.ip, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestLoadASNTSVInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.tsv")
+	if err := os.WriteFile(path, []byte(asnTestTSV+"1.0.8.0\tbad\t1\tUS\tBROKEN\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadASNTSV(path); err == nil || !strings.HasSuffix(err.Error(), ":5: invalid ASN record") {
+		t.Errorf("loadASNTSV() error = %v, want invalid record at line 5", err)
+	}
+}
+
+func TestRangeCIDR(t *testing.T) {
+	tests := []struct {
+		start string
+		end   string
+		ip    string
+		want  string
+	}{
+		{start: "1.0.4.0", end: "1.0.7.255", ip: "1.0.5.1", want: "1.0.4.0/22"},
+		{start: "10.0.0.0", end: "10.0.0.5", ip: "10.0.0.2", want: "10.0.0.0/30"},
+		{start: "10.0.0.0", end: "10.0.0.5", ip: "10.0.0.5", want: "10.0.0.4/31"},
+		{start: "10.0.0.1", end: "10.0.0.1", ip: "10.0.0.1", want: "10.0.0.1/32"},
+		{start: "2001:db8::", end: "2001:db8::ffff", ip: "2001:db8::1", want: "2001:db8::/112"},
+	}
+
+	for _, tt := range tests {
+		got := rangeCIDR(net.ParseIP(tt.start).To16(), net.ParseIP(tt.end).To16(), net.ParseIP(tt.ip).To16())
+		if got.String() != tt.want {
+			t.Errorf("rangeCIDR(%v, %v, %v) = %v, want %v", tt.start, tt.end, tt.ip, got, tt.want)
+		}
+	}
+}
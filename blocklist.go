@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BlocklistMatch struct {
+	List   string `json:"list"`
+	Prefix string `json:"prefix"`
+}
+
+// Blocklists matches addresses against every list file in Dir: FireHOL .netset/.ipset,
+// Spamhaus DROP or plain text files with one IP or CIDR per line
+type Blocklists struct {
+	Dir string
+
+	mu       sync.RWMutex
+	trie     *ipTrie
+	modTimes map[string]time.Time
+}
+
+func NewBlocklists(dir string) (*Blocklists, error) {
+	b := &Blocklists{Dir: dir}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Blocklists) Lookup(ip net.IP) []BlocklistMatch {
+	b.mu.RLock()
+	values := b.trie.Lookup(ip)
+	b.mu.RUnlock()
+
+	matches := make([]BlocklistMatch, 0, len(values))
+	for _, value := range values {
+		matches = append(matches, value.(BlocklistMatch))
+	}
+	return matches
+}
+
+// Reload rereads the list files if any of them was added, removed or changed
+func (b *Blocklists) Reload() error {
+	modTimes, err := dirModTimes(b.Dir)
+	if err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	changed := b.trie == nil || modTimesChanged(b.modTimes, modTimes)
+	b.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	trie := &ipTrie{}
+	for name := range modTimes {
+		if err := loadBlocklist(trie, filepath.Join(b.Dir, name)); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	b.trie = trie
+	b.modTimes = modTimes
+	b.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the lists every interval until ctx is done
+func (b *Blocklists) Watch(ctx context.Context, interval time.Duration) {
+	watchReload(ctx, interval, b.Reload)
+}
+
+func dirModTimes(dir string) (map[string]time.Time, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if !file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+			modTimes[file.Name()] = file.ModTime()
+		}
+	}
+	return modTimes, nil
+}
+
+func modTimesChanged(old, new map[string]time.Time) bool {
+	if len(old) != len(new) {
+		return true
+	}
+	for name, modTime := range new {
+		if !old[name].Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func watchReload(ctx context.Context, interval time.Duration, reload func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := reload(); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+func loadBlocklist(trie *ipTrie, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	list := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if network, ok := parseIPOrCIDR(fields[0]); ok {
+			trie.Insert(network, BlocklistMatch{List: list, Prefix: network.String()})
+		}
+	}
+	return scanner.Err()
+}
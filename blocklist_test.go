@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBlocklistsLookup(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"firehol_level1.netset": "#\n# firehol_level1\n#\n0.0.0.0/8\n192.0.2.0/24\n203.0.113.7\n",
+		"drop.txt":              "; Spamhaus DROP List\n192.0.2.128/25 ; SBL123456\n",
+		"custom.txt":            "2001:db8::/32   # test network\n\n198.51.100.1\n",
+		".hidden":               "192.0.2.1\n",
+	})
+
+	blocklists, err := NewBlocklists(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want []BlocklistMatch
+	}{
+		{ip: "192.0.2.200", want: []BlocklistMatch{
+			{List: "firehol_level1", Prefix: "192.0.2.0/24"},
+			{List: "drop", Prefix: "192.0.2.128/25"},
+		}},
+		{ip: "192.0.2.1", want: []BlocklistMatch{{List: "firehol_level1", Prefix: "192.0.2.0/24"}}},
+		{ip: "203.0.113.7", want: []BlocklistMatch{{List: "firehol_level1", Prefix: "203.0.113.7/32"}}},
+		{ip: "198.51.100.1", want: []BlocklistMatch{{List: "custom", Prefix: "198.51.100.1/32"}}},
+		{ip: "2001:db8::1", want: []BlocklistMatch{{List: "custom", Prefix: "2001:db8::/32"}}},
+		{ip: "203.0.113.8", want: []BlocklistMatch{}},
+	}
+	for _, tt := range tests {
+		if got := blocklists.Lookup(net.ParseIP(tt.ip)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lookup(%v) = %+v, want %+v", tt.ip, got, tt.want)
+		}
+	}
+
+	// A changed file is picked up on reload
+	path := filepath.Join(dir, "custom.txt")
+	writeTestFiles(t, dir, map[string]string{"custom.txt": "203.0.113.8\n"})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocklists.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := blocklists.Lookup(net.ParseIP("203.0.113.8")); len(got) != 1 || got[0].List != "custom" {
+		t.Errorf("Lookup() after reload = %+v", got)
+	}
+	if got := blocklists.Lookup(net.ParseIP("198.51.100.1")); len(got) != 0 {
+		t.Errorf("Lookup() of a removed entry = %+v", got)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Role is the access level a bot handler requires
+type Role int
+
+const (
+	// RoleAny handlers get updates without a sender too, e.g. channel posts
+	RoleAny Role = iota
+	RoleUser
+	RoleAdmin
+)
+
+// Update kinds, the bot API version in use has no my_chat_member updates
+const (
+	UpdateMessage           = "message"
+	UpdateEditedMessage     = "edited_message"
+	UpdateCallbackQuery     = "callback_query"
+	UpdateInlineQuery       = "inline_query"
+	UpdateChannelPost       = "channel_post"
+	UpdateEditedChannelPost = "edited_channel_post"
+)
+
+var ErrUnsupportedUpdate = errors.New("unsupported update")
+
+// BotContext carries one update through the middleware and its handler
+type BotContext struct {
+	Update tgbotapi.Update
+	Kind   string
+	// From is nil for channel posts
+	From   *tgbotapi.User
+	ChatID int64
+	// Text is the message text, the inline query or the callback data without its route prefix
+	Text string
+	User *User
+	// Reply is sent after the handler, if its text isn't empty
+	Reply tgbotapi.MessageConfig
+
+	router    *BotRouter
+	keepState bool
+}
+
+// BotHandlerFunc handles an update, a returned error is reported to the user. Use logMiddleware to log it
+type BotHandlerFunc func(c *BotContext) error
+
+type BotMiddleware func(next BotHandlerFunc) BotHandlerFunc
+
+type botRoute struct {
+	role    Role
+	handler BotHandlerFunc
+}
+
+// Dialogs stores the state of multi-message dialogs
+type Dialogs interface {
+	Get(chatID int64) (string, error)
+	Set(chatID int64, state string, expiresAt time.Time) error
+	Delete(chatID int64) error
+}
+
+// BotRouter dispatches updates to the handlers registered for commands, keyboard buttons,
+// callback queries, dialog states and the other update kinds. Updates without a handler are skipped
+type BotRouter struct {
+	Bot       *tgbotapi.BotAPI
+	Send      func(c tgbotapi.Chattable)
+	Dialogs   Dialogs
+	DialogTTL time.Duration
+	// Fallback handles text messages no route matches
+	Fallback BotHandlerFunc
+
+	commands   map[string]botRoute
+	buttons    map[string]botRoute
+	callbacks  map[string]botRoute
+	states     map[string]botRoute
+	updates    map[string]botRoute
+	middleware []BotMiddleware
+}
+
+func NewBotRouter(bot *tgbotapi.BotAPI, send func(c tgbotapi.Chattable), dialogs Dialogs, dialogTTL time.Duration) *BotRouter {
+	return &BotRouter{
+		Bot:       bot,
+		Send:      send,
+		Dialogs:   dialogs,
+		DialogTTL: dialogTTL,
+		commands:  make(map[string]botRoute),
+		buttons:   make(map[string]botRoute),
+		callbacks: make(map[string]botRoute),
+		states:    make(map[string]botRoute),
+		updates:   make(map[string]botRoute),
+	}
+}
+
+// Use adds middleware, the first one added is the outermost
+func (r *BotRouter) Use(middleware ...BotMiddleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+func (r *BotRouter) Command(command string, role Role, handler BotHandlerFunc) {
+	r.commands[command] = botRoute{role, handler}
+}
+
+func (r *BotRouter) Button(text string, role Role, handler BotHandlerFunc) {
+	r.buttons[text] = botRoute{role, handler}
+}
+
+// Callback handles callback queries with data "prefix" or "prefix:payload", the handler gets the payload as text
+func (r *BotRouter) Callback(prefix string, role Role, handler BotHandlerFunc) {
+	r.callbacks[prefix] = botRoute{role, handler}
+}
+
+// State handles text messages of chats in the dialog state. The dialog ends after the handler
+// succeeds, unless it calls RetryState or starts another dialog
+func (r *BotRouter) State(state string, role Role, handler BotHandlerFunc) {
+	r.states[state] = botRoute{role, handler}
+}
+
+// Update handles the updates of a kind other than messages and callback queries
+func (r *BotRouter) Update(kind string, role Role, handler BotHandlerFunc) {
+	r.updates[kind] = botRoute{role, handler}
+}
+
+func (r *BotRouter) Handle(update tgbotapi.Update) {
+	c, err := r.newContext(update)
+	if err != nil {
+		return
+	}
+
+	handler := r.dispatch
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+	if err := handler(c); err != nil {
+		// Senders of inline queries may have no chat with the bot, channels don't get error replies
+		if c.Kind == UpdateMessage || c.Kind == UpdateEditedMessage || c.Kind == UpdateCallbackQuery {
+			c.Reply.Text = "Something goes wrong\nTry again later"
+			c.Reply.ParseMode = ""
+		}
+	}
+	if c.Reply.Text != "" {
+		r.Send(c.Reply)
+	}
+}
+
+func (r *BotRouter) newContext(update tgbotapi.Update) (*BotContext, error) {
+	c := &BotContext{Update: update, router: r}
+	var message *tgbotapi.Message
+	switch {
+	case update.Message != nil:
+		c.Kind, message = UpdateMessage, update.Message
+	case update.EditedMessage != nil:
+		c.Kind, message = UpdateEditedMessage, update.EditedMessage
+	case update.ChannelPost != nil:
+		c.Kind, message = UpdateChannelPost, update.ChannelPost
+	case update.EditedChannelPost != nil:
+		c.Kind, message = UpdateEditedChannelPost, update.EditedChannelPost
+
+	case update.CallbackQuery != nil:
+		c.Kind = UpdateCallbackQuery
+		c.From = update.CallbackQuery.From
+		c.Text = update.CallbackQuery.Data
+		if c.From == nil {
+			return nil, ErrUnsupportedUpdate
+		}
+		// Buttons of inline mode messages have no chat, answer to the sender then
+		c.ChatID = int64(c.From.ID)
+		if update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil {
+			c.ChatID = update.CallbackQuery.Message.Chat.ID
+		}
+		c.Reply = tgbotapi.NewMessage(c.ChatID, "")
+		return c, nil
+
+	case update.InlineQuery != nil:
+		c.Kind = UpdateInlineQuery
+		c.From = update.InlineQuery.From
+		c.Text = update.InlineQuery.Query
+		if c.From == nil {
+			return nil, ErrUnsupportedUpdate
+		}
+		c.ChatID = int64(c.From.ID)
+		c.Reply = tgbotapi.NewMessage(c.ChatID, "")
+		return c, nil
+
+	default:
+		return nil, ErrUnsupportedUpdate
+	}
+
+	if message.Chat == nil {
+		return nil, ErrUnsupportedUpdate
+	}
+	c.From = message.From
+	c.ChatID = message.Chat.ID
+	c.Text = message.Text
+	c.Reply = tgbotapi.NewMessage(c.ChatID, "")
+	c.Reply.ReplyToMessageID = message.MessageID
+	return c, nil
+}
+
+func (r *BotRouter) dispatch(c *BotContext) error {
+	switch c.Kind {
+	case UpdateMessage:
+		return r.dispatchMessage(c)
+	case UpdateCallbackQuery:
+		return r.dispatchCallback(c)
+	}
+	if route, ok := r.updates[c.Kind]; ok && route.allows(c.User) {
+		return route.handler(c)
+	}
+	return nil
+}
+
+func (r *BotRouter) dispatchCallback(c *BotContext) error {
+	// The query must be answered, otherwise the client shows a progress indicator
+	if _, err := r.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(c.Update.CallbackQuery.ID, "")); err != nil {
+		log.Error(err)
+	}
+	prefix := c.Text
+	if i := strings.Index(prefix, ":"); i >= 0 {
+		prefix, c.Text = prefix[:i], prefix[i+1:]
+	}
+	if route, ok := r.callbacks[prefix]; ok && route.allows(c.User) {
+		return route.handler(c)
+	}
+	return nil
+}
+
+func (r *BotRouter) dispatchMessage(c *BotContext) error {
+	if c.User == nil {
+		return nil
+	}
+
+	message := c.Update.Message
+	if message.IsCommand() {
+		if route, ok := r.commands[message.Command()]; ok && route.allows(c.User) {
+			return route.handler(c)
+		}
+		c.Reply.Text = "I don't know that command"
+		return nil
+	}
+	if route, ok := r.buttons[message.Text]; ok && route.allows(c.User) {
+		return route.handler(c)
+	}
+
+	state, err := r.Dialogs.Get(c.ChatID)
+	if err != nil {
+		return err
+	}
+	if route, ok := r.states[state]; ok && route.allows(c.User) {
+		if err := route.handler(c); err != nil {
+			return err
+		}
+		if !c.keepState {
+			return r.Dialogs.Delete(c.ChatID)
+		}
+		return nil
+	}
+	if r.Fallback != nil {
+		return r.Fallback(c)
+	}
+	return nil
+}
+
+func (route botRoute) allows(user *User) bool {
+	switch route.role {
+	case RoleAny:
+		return true
+	case RoleAdmin:
+		return user != nil && user.IsAdmin
+	default:
+		return user != nil
+	}
+}
+
+// StartDialog puts the chat into the dialog state and sets the reply to the prompt
+func (c *BotContext) StartDialog(state string, prompt string) error {
+	if err := c.router.Dialogs.Set(c.ChatID, state, time.Now().Add(c.router.DialogTTL)); err != nil {
+		return err
+	}
+	c.Reply.Text = prompt + dialogCancelHint
+	c.keepState = true
+	return nil
+}
+
+// RetryState keeps the chat in the current dialog state, e.g. after an invalid answer
+func (c *BotContext) RetryState() {
+	c.keepState = true
+}
+
+// CancelDialog ends the dialog of the chat, it returns false if there is none
+func (c *BotContext) CancelDialog() (bool, error) {
+	state, err := c.router.Dialogs.Get(c.ChatID)
+	if err != nil || state == "" {
+		return false, err
+	}
+	return true, c.router.Dialogs.Delete(c.ChatID)
+}
+
+// recoverMiddleware turns a handler panic into an error, so the bot keeps serving other updates
+func recoverMiddleware(next BotHandlerFunc) BotHandlerFunc {
+	return func(c *BotContext) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic in bot handler: %v\n%s", r, debug.Stack())
+			}
+		}()
+		return next(c)
+	}
+}
+
+// slowBotUpdate is the handling time after which an update is logged as slow
+const slowBotUpdate = 30 * time.Second
+
+// logMiddleware logs failed and slow updates with their chat and sender. It should be the outermost
+// middleware, so errors made of handler panics are logged too
+func logMiddleware(next BotHandlerFunc) BotHandlerFunc {
+	return func(c *BotContext) error {
+		start := time.Now()
+		err := next(c)
+		duration := time.Since(start)
+		if err == nil && duration < slowBotUpdate {
+			return nil
+		}
+
+		fields := log.Fields{
+			"kind":     c.Kind,
+			"chat_id":  c.ChatID,
+			"duration": duration,
+		}
+		if c.From != nil {
+			fields["tg_id"] = c.From.ID
+		}
+		if err != nil {
+			log.WithFields(fields).WithError(err).Error("bot update failed")
+		} else {
+			log.WithFields(fields).Error("bot update is slow")
+		}
+		return err
+	}
+}
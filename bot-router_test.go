@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestLogMiddleware(t *testing.T) {
+	logs := &bytes.Buffer{}
+	defer log.SetOutput(log.StandardLogger().Out)
+	log.SetOutput(logs)
+
+	sent := make([]tgbotapi.Chattable, 0)
+	router := NewBotRouter(nil, func(c tgbotapi.Chattable) { sent = append(sent, c) }, nil, time.Minute)
+	router.Use(logMiddleware, recoverMiddleware)
+
+	tests := []struct {
+		name    string
+		handler BotHandlerFunc
+		wantLog string
+	}{
+		{name: "success", handler: func(c *BotContext) error { return nil }},
+		{name: "error", handler: func(c *BotContext) error { return errors.New("db is down") }, wantLog: "db is down"},
+		{name: "panic", handler: func(c *BotContext) error { panic("nil map") }, wantLog: "panic in bot handler: nil map"},
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		sent = sent[:0]
+		router.Update(UpdateEditedMessage, RoleAny, tt.handler)
+		router.Handle(tgbotapi.Update{EditedMessage: &tgbotapi.Message{
+			MessageID: 1,
+			Chat:      &tgbotapi.Chat{ID: 42},
+			Text:      "secret text",
+		}})
+
+		output := logs.String()
+		if tt.wantLog == "" {
+			if output != "" {
+				t.Errorf("%v: logged %q, want nothing", tt.name, output)
+			}
+			continue
+		}
+		if strings.Count(output, "bot update failed") != 1 || !strings.Contains(output, tt.wantLog) || !strings.Contains(output, "chat_id=42") {
+			t.Errorf("%v: logged %q, want one failed update with %q", tt.name, output, tt.wantLog)
+		}
+		if strings.Contains(output, "secret text") {
+			t.Errorf("%v: logged the message text: %q", tt.name, output)
+		}
+		if len(sent) != 1 {
+			t.Errorf("%v: sent %v replies, want the error reply", tt.name, len(sent))
+		}
+	}
+}
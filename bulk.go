@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxBulkEntries     = 100
+	maxMessageLength   = 4096
+	defaultBulkWorkers = 8
+)
+
+// splitBulkQuery splits a message with many queries separated by whitespace, commas or semicolons
+func splitBulkQuery(text string) []string {
+	entries := strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == ';'
+	})
+
+	uniq := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !seen[entry] {
+			seen[entry] = true
+			uniq = append(uniq, entry)
+		}
+	}
+	return uniq
+}
+
+// runPool calls fn for every index in [0, n) using at most workers goroutines
+func runPool(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// paginate joins parts with blank lines into messages that fit the Telegram length limit.
+// Oversized parts are split at section and line boundaries first, see splitMessage
+func paginate(parts []string, limit int) []string {
+	pieces := make([]string, 0, len(parts))
+	for _, part := range parts {
+		pieces = append(pieces, splitMessage(part, limit)...)
+	}
+	return joinPages(pieces, "\n\n", limit)
+}
+
+// joinPages greedily joins pieces no longer than limit with sep into pages no longer than limit
+func joinPages(pieces []string, sep string, limit int) []string {
+	pages := make([]string, 0, 1)
+	page := ""
+	for _, piece := range pieces {
+		switch {
+		case page == "":
+			page = piece
+		case len(page)+len(sep)+len(piece) <= limit:
+			page += sep + piece
+		default:
+			pages = append(pages, page)
+			page = piece
+		}
+	}
+	if page != "" {
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// splitMessage splits an HTML message longer than limit at blank lines, then at line breaks.
+// Only a single line longer than limit is cut, see cutHTML
+func splitMessage(text string, limit int) []string {
+	if len(text) <= limit {
+		return []string{text}
+	}
+	for _, sep := range []string{"\n\n", "\n"} {
+		if !strings.Contains(text, sep) {
+			continue
+		}
+		pieces := make([]string, 0)
+		for _, piece := range strings.Split(text, sep) {
+			pieces = append(pieces, splitMessage(piece, limit)...)
+		}
+		return joinPages(pieces, sep, limit)
+	}
+	return cutHTML(text, limit)
+}
+
+type htmlElement struct {
+	name string
+	open string
+}
+
+// cutHTML cuts a line longer than limit between characters outside tags and entities.
+// Elements open at a cut are closed in the chunk and reopened in the next one
+func cutHTML(text string, limit int) []string {
+	chunks := make([]string, 0, 2)
+	for len(text) > limit {
+		cut, cutStack := 0, []htmlElement(nil)
+		stack := make([]htmlElement, 0)
+		for i := 0; i <= limit && i < len(text); {
+			if i > 0 && i+len(closeElements(stack)) <= limit {
+				cut, cutStack = i, append([]htmlElement(nil), stack...)
+			}
+
+			size := htmlTokenSize(text[i:])
+			token := text[i : i+size]
+			if fields := strings.Fields(strings.Trim(token, "<>/")); size > 2 && token[0] == '<' && len(fields) > 0 {
+				switch {
+				case strings.HasPrefix(token, "</"):
+					if len(stack) > 0 {
+						stack = stack[:len(stack)-1]
+					}
+				case !strings.HasSuffix(token, "/>"):
+					stack = append(stack, htmlElement{name: fields[0], open: token})
+				}
+			}
+			i += size
+		}
+		if cut == 0 {
+			// No room even for the first token, cut it at a character boundary
+			cut = limit
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+		}
+
+		chunks = append(chunks, text[:cut]+closeElements(cutStack))
+		reopen := ""
+		for _, element := range cutStack {
+			reopen += element.open
+		}
+		text = reopen + text[cut:]
+	}
+	return append(chunks, text)
+}
+
+// htmlTokenSize returns the byte length of the tag, entity or character text starts with
+func htmlTokenSize(text string) int {
+	switch text[0] {
+	case '<':
+		if end := strings.IndexByte(text, '>'); end > 1 {
+			return end + 1
+		}
+	case '&':
+		if end := strings.IndexByte(text, ';'); end > 1 && end <= 10 {
+			return end + 1
+		}
+	}
+	_, size := utf8.DecodeRuneInString(text)
+	return size
+}
+
+func closeElements(stack []htmlElement) string {
+	closing := ""
+	for i := len(stack) - 1; i >= 0; i-- {
+		closing += "</" + stack[i].name + ">"
+	}
+	return closing
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPaginate(t *testing.T) {
+	section := "<b>Geo</b>\n<code>City:</code> Москва\n<code>Region:</code> Moscow"
+	long := "<code>Hostnames:</code> " + strings.Repeat("host-имя.example.com, ", 40) + "&amp; more"
+
+	tests := []struct {
+		name  string
+		parts []string
+		limit int
+		pages int
+	}{
+		{name: "fits", parts: []string{section, section}, limit: 4096, pages: 1},
+		{name: "by sections", parts: []string{section, section, section}, limit: len(section) + 2, pages: 3},
+		{name: "by lines", parts: []string{section}, limit: 45, pages: 2},
+		{name: "long line", parts: []string{long}, limit: 100, pages: 11},
+	}
+
+	for _, tt := range tests {
+		pages := paginate(tt.parts, tt.limit)
+		if len(pages) != tt.pages {
+			t.Errorf("%v: got %v pages, want %v", tt.name, len(pages), tt.pages)
+		}
+		for _, page := range pages {
+			if len(page) > tt.limit {
+				t.Errorf("%v: page is %v bytes long, limit %v", tt.name, len(page), tt.limit)
+			}
+			if !utf8.ValidString(page) {
+				t.Errorf("%v: page %q has a broken character", tt.name, page)
+			}
+			if strings.Count(page, "<code>") != strings.Count(page, "</code>") || strings.Count(page, "<b>") != strings.Count(page, "</b>") {
+				t.Errorf("%v: page %q has unclosed tags", tt.name, page)
+			}
+			if strings.Count(page, "&") != strings.Count(page, "&amp;") {
+				t.Errorf("%v: page %q has a broken entity", tt.name, page)
+			}
+		}
+	}
+
+	if pages := paginate([]string{section}, 45); strings.Join(pages, "\n") != section {
+		t.Errorf("split by lines lost content: %q", pages)
+	}
+
+	// Elements open at a cut are reopened in the next page
+	pages := paginate([]string{"<code>" + strings.Repeat("a", 30) + "</code>"}, 25)
+	for _, page := range pages {
+		if !strings.HasPrefix(page, "<code>") || !strings.HasSuffix(page, "</code>") {
+			t.Errorf("page %q isn't a closed element", page)
+		}
+	}
+}
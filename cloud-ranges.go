@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CloudMatch struct {
+	Provider string `json:"provider"`
+	Service  string `json:"service,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Prefix   string `json:"prefix"`
+}
+
+type cloudRangesLoader func(trie *ipTrie, path string) error
+
+// Published range files are recognized by their usual names
+var cloudRangesLoaders = []struct {
+	pattern string
+	load    cloudRangesLoader
+}{
+	{"exit-addresses*", loadTorExitList},
+	{"tor*", loadTorExitList},
+	{"ip-ranges.json", loadAWSRanges},
+	{"cloud.json", loadGCPRanges},
+	{"ServiceTags_Public*.json", loadAzureRanges},
+	{"cloudflare*", loadCloudflareRanges},
+	{"ips-v4*", loadCloudflareRanges},
+	{"ips-v6*", loadCloudflareRanges},
+}
+
+// CloudRanges detects Tor exit nodes and public cloud addresses using the range files in Dir
+type CloudRanges struct {
+	Dir string
+
+	mu       sync.RWMutex
+	trie     *ipTrie
+	modTimes map[string]time.Time
+}
+
+func NewCloudRanges(dir string) (*CloudRanges, error) {
+	c := &CloudRanges{Dir: dir}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Lookup returns matching ranges, the most specific first
+func (c *CloudRanges) Lookup(ip net.IP) []CloudMatch {
+	c.mu.RLock()
+	values := c.trie.Lookup(ip)
+	c.mu.RUnlock()
+
+	matches := make([]CloudMatch, 0, len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		matches = append(matches, values[i].(CloudMatch))
+	}
+	return matches
+}
+
+func (c *CloudRanges) Reload() error {
+	modTimes, err := dirModTimes(c.Dir)
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	changed := c.trie == nil || modTimesChanged(c.modTimes, modTimes)
+	c.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	trie := &ipTrie{}
+	for name := range modTimes {
+		for _, loader := range cloudRangesLoaders {
+			if ok, _ := filepath.Match(loader.pattern, name); ok {
+				if err := loader.load(trie, filepath.Join(c.Dir, name)); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.trie = trie
+	c.modTimes = modTimes
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *CloudRanges) Watch(ctx context.Context, interval time.Duration) {
+	watchReload(ctx, interval, c.Reload)
+}
+
+func insertCloudRange(trie *ipTrie, cidr string, match CloudMatch) {
+	if network, ok := parseIPOrCIDR(strings.TrimSpace(cidr)); ok {
+		match.Prefix = network.String()
+		trie.Insert(network, match)
+	}
+}
+
+// loadTorExitList reads both the plain bulk exit list and the exit-addresses format
+func loadTorExitList(trie *ipTrie, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) >= 2 && fields[0] == "ExitAddress":
+			insertCloudRange(trie, fields[1], CloudMatch{Provider: "Tor", Service: "exit node"})
+		case len(fields) >= 1 && !strings.HasPrefix(fields[0], "#"):
+			insertCloudRange(trie, fields[0], CloudMatch{Provider: "Tor", Service: "exit node"})
+		}
+	}
+	return scanner.Err()
+}
+
+func loadAWSRanges(trie *ipTrie, path string) error {
+	data := struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}{}
+	if err := readJSONFile(path, &data); err != nil {
+		return err
+	}
+
+	for _, prefix := range data.Prefixes {
+		insertCloudRange(trie, prefix.IPPrefix, CloudMatch{Provider: "AWS", Service: prefix.Service, Region: prefix.Region})
+	}
+	for _, prefix := range data.IPv6Prefixes {
+		insertCloudRange(trie, prefix.IPv6Prefix, CloudMatch{Provider: "AWS", Service: prefix.Service, Region: prefix.Region})
+	}
+	return nil
+}
+
+func loadGCPRanges(trie *ipTrie, path string) error {
+	data := struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}{}
+	if err := readJSONFile(path, &data); err != nil {
+		return err
+	}
+
+	for _, prefix := range data.Prefixes {
+		match := CloudMatch{Provider: "GCP", Service: prefix.Service, Region: prefix.Scope}
+		if prefix.IPv4Prefix != "" {
+			insertCloudRange(trie, prefix.IPv4Prefix, match)
+		}
+		if prefix.IPv6Prefix != "" {
+			insertCloudRange(trie, prefix.IPv6Prefix, match)
+		}
+	}
+	return nil
+}
+
+func loadAzureRanges(trie *ipTrie, path string) error {
+	data := struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}{}
+	if err := readJSONFile(path, &data); err != nil {
+		return err
+	}
+
+	for _, value := range data.Values {
+		// Global tags like AzureCloud repeat the regional ones, only regional tags are kept
+		if value.Properties.Region == "" {
+			continue
+		}
+		service := value.Properties.SystemService
+		if service == "" {
+			service = value.Name
+		}
+		for _, prefix := range value.Properties.AddressPrefixes {
+			insertCloudRange(trie, prefix, CloudMatch{Provider: "Azure", Service: service, Region: value.Properties.Region})
+		}
+	}
+	return nil
+}
+
+func loadCloudflareRanges(trie *ipTrie, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		insertCloudRange(trie, scanner.Text(), CloudMatch{Provider: "Cloudflare"})
+	}
+	return scanner.Err()
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCloudRangesLookup(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"exit-addresses": "ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E\n" +
+			"Published 2024-01-01 10:00:00\n" +
+			"LastStatus 2024-01-01 11:00:00\n" +
+			"ExitAddress 198.51.100.10 2024-01-01 11:00:00\n",
+		"tor-bulk-exit-list.txt": "# Tor exit list\n198.51.100.20\n",
+		"ip-ranges.json": `{"prefixes": [
+			{"ip_prefix": "3.5.0.0/16", "region": "eu-west-1", "service": "AMAZON"},
+			{"ip_prefix": "3.5.140.0/22", "region": "eu-west-1", "service": "S3"}
+		], "ipv6_prefixes": [
+			{"ipv6_prefix": "2600:1f00::/24", "region": "us-east-1", "service": "AMAZON"}
+		]}`,
+		"cloud.json": `{"prefixes": [
+			{"ipv4Prefix": "34.80.0.0/15", "service": "Google Cloud", "scope": "asia-east1"},
+			{"ipv6Prefix": "2600:1900:4000::/44", "service": "Google Cloud", "scope": "us-central1"}
+		]}`,
+		"ServiceTags_Public_20240101.json": `{"values": [
+			{"name": "AzureCloud", "properties": {"region": "", "addressPrefixes": ["20.0.0.0/8"]}},
+			{"name": "AzureCloud.westeurope", "properties": {"region": "westeurope", "systemService": "", "addressPrefixes": ["20.50.0.0/16"]}}
+		]}`,
+		"ips-v4":      "173.245.48.0/20\n",
+		"ips-v6":      "2400:cb00::/32\n",
+		"unknown.txt": "192.0.2.0/24\n",
+	})
+
+	ranges, err := NewCloudRanges(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want []CloudMatch
+	}{
+		{ip: "198.51.100.10", want: []CloudMatch{{Provider: "Tor", Service: "exit node", Prefix: "198.51.100.10/32"}}},
+		{ip: "198.51.100.20", want: []CloudMatch{{Provider: "Tor", Service: "exit node", Prefix: "198.51.100.20/32"}}},
+		// The most specific range comes first
+		{ip: "3.5.140.1", want: []CloudMatch{
+			{Provider: "AWS", Service: "S3", Region: "eu-west-1", Prefix: "3.5.140.0/22"},
+			{Provider: "AWS", Service: "AMAZON", Region: "eu-west-1", Prefix: "3.5.0.0/16"},
+		}},
+		{ip: "2600:1f00::1", want: []CloudMatch{{Provider: "AWS", Service: "AMAZON", Region: "us-east-1", Prefix: "2600:1f00::/24"}}},
+		{ip: "34.81.0.1", want: []CloudMatch{{Provider: "GCP", Service: "Google Cloud", Region: "asia-east1", Prefix: "34.80.0.0/15"}}},
+		{ip: "2600:1900:4000::1", want: []CloudMatch{{Provider: "GCP", Service: "Google Cloud", Region: "us-central1", Prefix: "2600:1900:4000::/44"}}},
+		// Global Azure tags are skipped, the service falls back to the tag name
+		{ip: "20.50.1.1", want: []CloudMatch{{Provider: "Azure", Service: "AzureCloud.westeurope", Region: "westeurope", Prefix: "20.50.0.0/16"}}},
+		{ip: "20.1.1.1", want: []CloudMatch{}},
+		{ip: "173.245.48.1", want: []CloudMatch{{Provider: "Cloudflare", Prefix: "173.245.48.0/20"}}},
+		{ip: "2400:cb00::1", want: []CloudMatch{{Provider: "Cloudflare", Prefix: "2400:cb00::/32"}}},
+		{ip: "192.0.2.1", want: []CloudMatch{}},
+	}
+	for _, tt := range tests {
+		if got := ranges.Lookup(net.ParseIP(tt.ip)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lookup(%v) = %+v, want %+v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestCloudRangesInvalidJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"ip-ranges.json": `{"prefixes": [`})
+	if _, err := NewCloudRanges(dir); err == nil {
+		t.Error("NewCloudRanges() should fail on a broken range file")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"net/http"
 	"strconv"
 	"time"
@@ -18,16 +19,26 @@ type User struct {
 	TgLastName     string
 	TgLanguageCode string
 	IsAdmin        bool `gorm:"not null;default false"`
+	SendLocation   bool `gorm:"not null;default:true"`
 
 	CreatedAt time.Time      `gorm:"autoCreateTime"`
 	UpdatedAt time.Time      `gorm:"autoUpdateTime"`
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
+const (
+	IPCheckKindIP     = "ip"
+	IPCheckKindSubnet = "subnet"
+)
+
 type IPCheck struct {
 	ID       int `gorm:"primaryKey;autoIncrement"`
 	IP       string
+	Kind     string `gorm:"not null;default:ip"`
+	Query    string
 	IPInfo   datatypes.JSON
+	Provider string
+	Class    string
 	UserTgID int
 	User     User `json:"-"`
 
@@ -36,6 +47,29 @@ type IPCheck struct {
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
+type WatchedIP struct {
+	ID        int    `gorm:"primaryKey;autoIncrement"`
+	IP        string `gorm:"uniqueIndex:idx_watched_ip_user"`
+	IPInfo    datatypes.JSON
+	UserTgID  int  `gorm:"uniqueIndex:idx_watched_ip_user"`
+	User      User `json:"-"`
+	CheckedAt time.Time
+
+	CreatedAt time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// DialogState is the step of a multi-message bot dialog the chat is in
+type DialogState struct {
+	ChatID    int64     `gorm:"primaryKey;autoIncrement:false"`
+	State     string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
 type ErrLog struct {
 	ID    int `gorm:"primaryKey;autoIncrement"`
 	Error string
@@ -45,7 +79,12 @@ type ErrLog struct {
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
-var ErrUserNotFound = errors.New("user not found")
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrIPCheckNotFound   = errors.New("IP check not found")
+	ErrWatchedIPNotFound = errors.New("watched IP not found")
+	ErrWatchedIPExists   = errors.New("IP is already watched")
+)
 
 type UserModel struct {
 	DB *gorm.DB
@@ -113,6 +152,13 @@ func (um UserModel) SetAdminStatus(tgID int, isAdmin bool) error {
 	return nil
 }
 
+func (um *UserModel) SetSendLocation(tgID int, sendLocation bool) error {
+	if result := um.DB.Model(&User{}).Where("tg_id = ?", tgID).Update("send_location", sendLocation); result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
 func (um *UserModel) HandlerGetUsers(w http.ResponseWriter, r *http.Request) {
 	users, err := um.List()
 	if err != nil {
@@ -230,6 +276,42 @@ func (ipcm *IPCheckModel) ListByTgID(tgID int, uniq bool) ([]IPCheck, error) {
 	return ipChecks, nil
 }
 
+func (ipcm *IPCheckModel) Get(ipCheckID int) (*IPCheck, error) {
+	ipCheck := IPCheck{}
+	if result := ipcm.DB.First(&ipCheck, ipCheckID); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrIPCheckNotFound
+		}
+		return nil, result.Error
+	}
+	return &ipCheck, nil
+}
+
+// GetLatestByTgID returns the last check of the address by the user, or nil if the user never checked it
+func (ipcm *IPCheckModel) GetLatestByTgID(tgID int, ip string) (*IPCheck, error) {
+	ipCheck := IPCheck{}
+	result := ipcm.DB.Where("user_tg_id = ? AND ip = ?", tgID, ip).Order("created_at desc").First(&ipCheck)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &ipCheck, nil
+}
+
+func (ipcm *IPCheckModel) GetLatestByIP(ip string, since time.Time) (*IPCheck, error) {
+	ipCheck := IPCheck{}
+	result := ipcm.DB.Where("ip = ? AND created_at >= ?", ip, since).Order("created_at desc").First(&ipCheck)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &ipCheck, nil
+}
+
 func (ipcm *IPCheckModel) Insert(ipCheck *IPCheck) error {
 	err := ipcm.DB.Create(ipCheck).Error
 	if err != nil {
@@ -291,6 +373,65 @@ func (ipcm *IPCheckModel) HandlerGetHistory(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func (ipcm *IPCheckModel) HandlerGetHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	writeError := func(status int, err error) {
+		badResp, err := NewErrorResponse(err).toJSON()
+		if err != nil {
+			log.Error(err)
+		}
+		w.WriteHeader(status)
+		_, err = w.Write(badResp)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+
+	ipChecks := make([]*IPCheck, 0, 2)
+	for _, param := range []string{"fromID", "toID"} {
+		ipCheckID, err := strconv.Atoi(r.FormValue(param))
+		if err != nil {
+			writeError(http.StatusBadRequest, err)
+			return
+		}
+		ipCheck, err := ipcm.Get(ipCheckID)
+		if errors.Is(err, ErrIPCheckNotFound) {
+			writeError(http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(http.StatusInternalServerError, err)
+			return
+		}
+		ipChecks = append(ipChecks, ipCheck)
+	}
+
+	diff, err := diffIPChecks(ipChecks[0], ipChecks[1])
+	if errors.Is(err, ErrIPCheckDiffMismatch) {
+		writeError(http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		writeError(http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := Response{
+		Success:     true,
+		IPCheckDiff: diff,
+	}
+
+	respByte, err := resp.toJSON()
+	if err != nil {
+		log.Error(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(respByte)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
 func (ipcm *IPCheckModel) HandlerDeleteHistoryRecord(w http.ResponseWriter, r *http.Request) {
 	userTgID, err := strconv.Atoi(r.FormValue("ipCheckID"))
 	if err != nil {
@@ -335,6 +476,115 @@ func (ipcm *IPCheckModel) HandlerDeleteHistoryRecord(w http.ResponseWriter, r *h
 	}
 }
 
+type WatchedIPModel struct {
+	DB *gorm.DB
+}
+
+func (wm *WatchedIPModel) ListByTgID(tgID int) ([]WatchedIP, error) {
+	watchedIPs := make([]WatchedIP, 0, 5)
+	if result := wm.DB.Where("user_tg_id = ?", tgID).Order("id").Find(&watchedIPs); result.Error != nil {
+		return nil, result.Error
+	}
+	return watchedIPs, nil
+}
+
+func (wm *WatchedIPModel) ListDue(checkedBefore time.Time) ([]WatchedIP, error) {
+	watchedIPs := make([]WatchedIP, 0, 5)
+	if result := wm.DB.Where("checked_at <= ?", checkedBefore).Find(&watchedIPs); result.Error != nil {
+		return nil, result.Error
+	}
+	return watchedIPs, nil
+}
+
+func (wm *WatchedIPModel) Insert(watchedIP *WatchedIP) error {
+	var count int64
+	if result := wm.DB.Model(&WatchedIP{}).Where("user_tg_id = ? AND ip = ?", watchedIP.UserTgID, watchedIP.IP).Count(&count); result.Error != nil {
+		return result.Error
+	}
+	if count > 0 {
+		return ErrWatchedIPExists
+	}
+
+	// Removed rows are soft-deleted, but still hold the unique index
+	if result := wm.DB.Unscoped().Where("user_tg_id = ? AND ip = ? AND deleted_at IS NOT NULL", watchedIP.UserTgID, watchedIP.IP).Delete(&WatchedIP{}); result.Error != nil {
+		return result.Error
+	}
+	if result := wm.DB.Create(watchedIP); result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (wm *WatchedIPModel) UpdateInfo(id int, ipInfo datatypes.JSON) error {
+	updateData := map[string]interface{}{
+		"ip_info":    ipInfo,
+		"checked_at": time.Now(),
+	}
+	if result := wm.DB.Model(&WatchedIP{}).Where("id = ?", id).Updates(updateData); result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (wm *WatchedIPModel) Delete(tgID int, ip string) error {
+	result := wm.DB.Where("user_tg_id = ? AND ip = ?", tgID, ip).Delete(&WatchedIP{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWatchedIPNotFound
+	}
+	return nil
+}
+
+type DialogStateModel struct {
+	DB *gorm.DB
+}
+
+// Get returns the chat state, or empty string if the chat isn't in a dialog or the dialog has expired
+func (dsm *DialogStateModel) Get(chatID int64) (string, error) {
+	dialogState := DialogState{}
+	result := dsm.DB.Where("chat_id = ? AND expires_at > ?", chatID, time.Now()).First(&dialogState)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", result.Error
+	}
+	return dialogState.State, nil
+}
+
+func (dsm *DialogStateModel) Set(chatID int64, state string, expiresAt time.Time) error {
+	dialogState := DialogState{
+		ChatID:    chatID,
+		State:     state,
+		ExpiresAt: expiresAt,
+	}
+	result := dsm.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"state", "expires_at", "updated_at"}),
+	}).Create(&dialogState)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (dsm *DialogStateModel) Delete(chatID int64) error {
+	if result := dsm.DB.Delete(&DialogState{}, chatID); result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// DeleteExpired removes the states of the dialogs abandoned by their chats
+func (dsm *DialogStateModel) DeleteExpired() error {
+	if result := dsm.DB.Where("expires_at <= ?", time.Now()).Delete(&DialogState{}); result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
 type ErrLogModel struct {
 	DB *gorm.DB
 }
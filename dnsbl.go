@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DNSBLResult struct {
+	Zone   string   `json:"zone"`
+	Listed bool     `json:"listed"`
+	Codes  []string `json:"codes,omitempty"`
+}
+
+type dnsblCacheEntry struct {
+	result    DNSBLResult
+	expiresAt time.Time
+}
+
+// HostResolver is implemented by *net.Resolver, tests stub it
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNSBLChecker queries DNS blocklist zones, answers are cached for TTL
+type DNSBLChecker struct {
+	Resolver HostResolver
+	Zones    []string
+	TTL      time.Duration
+
+	mu        sync.Mutex
+	cache     map[string]dnsblCacheEntry
+	lastPurge time.Time
+}
+
+func NewDNSBLChecker(resolver HostResolver, zones []string, ttl time.Duration) *DNSBLChecker {
+	return &DNSBLChecker{
+		Resolver:  resolver,
+		Zones:     zones,
+		TTL:       ttl,
+		cache:     make(map[string]dnsblCacheEntry),
+		lastPurge: time.Now(),
+	}
+}
+
+// Check queries every zone concurrently, results of failed zones are left out
+func (c *DNSBLChecker) Check(ctx context.Context, ip net.IP) ([]DNSBLResult, error) {
+	results := make([]DNSBLResult, len(c.Zones))
+	errs := make([]error, len(c.Zones))
+	wg := sync.WaitGroup{}
+	for i, zone := range c.Zones {
+		wg.Add(1)
+		go func(i int, zone string) {
+			defer wg.Done()
+			results[i], errs[i] = c.checkZone(ctx, ip, zone)
+		}(i, zone)
+	}
+	wg.Wait()
+
+	checked := make([]DNSBLResult, 0, len(results))
+	failed := make([]string, 0)
+	for i := range results {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", c.Zones[i], errs[i]))
+			continue
+		}
+		checked = append(checked, results[i])
+	}
+	if len(failed) > 0 {
+		return checked, errors.New(strings.Join(failed, "; "))
+	}
+	return checked, nil
+}
+
+func (c *DNSBLChecker) checkZone(ctx context.Context, ip net.IP, zone string) (DNSBLResult, error) {
+	name := dnsblQueryName(ip, zone)
+
+	c.mu.Lock()
+	entry, ok := c.cache[name]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.result, nil
+	}
+
+	result := DNSBLResult{Zone: zone}
+	addrs, err := c.Resolver.LookupHost(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return result, err
+		}
+	} else {
+		result.Listed = true
+		result.Codes = addrs
+		sort.Strings(result.Codes)
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	if now.Sub(c.lastPurge) > c.TTL {
+		for k, entry := range c.cache {
+			if now.After(entry.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+		c.lastPurge = now
+	}
+	c.cache[name] = dnsblCacheEntry{result: result, expiresAt: now.Add(c.TTL)}
+	c.mu.Unlock()
+	return result, nil
+}
+
+// dnsblQueryName reverses IPv4 octets or IPv6 nibbles and appends the zone
+func dnsblQueryName(ip net.IP, zone string) string {
+	labels := make([]string, 0, 32)
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(ip4[i]))
+		}
+	} else {
+		ip16 := ip.To16()
+		for i := len(ip16) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprintf("%x", ip16[i]&0x0f), fmt.Sprintf("%x", ip16[i]>>4))
+		}
+	}
+	return strings.Join(labels, ".") + "." + strings.TrimSuffix(zone, ".") + "."
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDNSBLQueryName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		zone string
+		want string
+	}{
+		{ip: "127.0.0.2", zone: "zen.spamhaus.org", want: "2.0.0.127.zen.spamhaus.org."},
+		{ip: "192.0.2.99", zone: "bl.spamcop.net.", want: "99.2.0.192.bl.spamcop.net."},
+		{ip: "::ffff:1.2.3.4", zone: "example.org", want: "4.3.2.1.example.org."},
+		{
+			ip:   "2001:db8:1:2::3",
+			zone: "example.org",
+			want: "3.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.2.0.0.0.1.0.0.0.8.b.d.0.1.0.0.2.example.org.",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := dnsblQueryName(net.ParseIP(tt.ip), tt.zone); got != tt.want {
+			t.Errorf("dnsblQueryName(%v, %v) = %v, want %v", tt.ip, tt.zone, got, tt.want)
+		}
+	}
+}
+
+// stubResolver answers from a map, unknown names are NXDOMAIN
+type stubResolver struct {
+	mu      sync.Mutex
+	answers map[string][]string
+	errs    map[string]error
+	queries int
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries++
+	if err, ok := r.errs[host]; ok {
+		return nil, err
+	}
+	if addrs, ok := r.answers[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestDNSBLCheckerCheck(t *testing.T) {
+	resolver := &stubResolver{
+		answers: map[string][]string{
+			"2.0.0.127.listed.example.": {"127.0.0.4", "127.0.0.2"},
+		},
+		errs: map[string]error{
+			"2.0.0.127.broken.example.": &net.DNSError{Err: "server misbehaving", Name: "broken.example", IsTemporary: true},
+		},
+	}
+	checker := NewDNSBLChecker(resolver, []string{"listed.example", "clean.example", "broken.example"}, time.Hour)
+
+	results, err := checker.Check(context.Background(), net.ParseIP("127.0.0.2"))
+	want := []DNSBLResult{
+		{Zone: "listed.example", Listed: true, Codes: []string{"127.0.0.2", "127.0.0.4"}},
+		{Zone: "clean.example"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("Check() = %+v, want %+v", results, want)
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "broken.example: ") {
+		t.Errorf("Check() error = %v, want the broken.example zone error", err)
+	}
+
+	// Answers of the zones which didn't fail are cached
+	if _, err := checker.Check(context.Background(), net.ParseIP("127.0.0.2")); err == nil {
+		t.Error("Check() of the broken zone should fail again")
+	}
+	if resolver.queries != 4 {
+		t.Errorf("resolver got %v queries, want 4", resolver.queries)
+	}
+}
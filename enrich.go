@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Enricher is one step of an IP check, it adds its fields to the check result
+type Enricher interface {
+	Name() string
+	Enrich(ctx context.Context, ip net.IP, ipInfo *IPInfo) error
+}
+
+// ErrorTexter lets an enricher decide what users see when it fails
+type ErrorTexter interface {
+	ErrorText(err error) string
+}
+
+type enrichStep struct {
+	enricher Enricher
+	timeout  time.Duration
+}
+
+type EnrichmentPipeline struct {
+	steps []enrichStep
+}
+
+func (p *EnrichmentPipeline) Add(enricher Enricher, timeout time.Duration) {
+	p.steps = append(p.steps, enrichStep{enricher: enricher, timeout: timeout})
+}
+
+// Run passes the address through every step in order. A failed step is recorded in
+// IPInfo.Errors and returned in the map, the other steps still run
+func (p *EnrichmentPipeline) Run(ctx context.Context, ip net.IP) (*IPInfo, map[string]error) {
+	ipInfo := &IPInfo{IP: ip.String(), Type: ipType(ip)}
+	errs := make(map[string]error)
+	for _, step := range p.steps {
+		if err := runEnrichStep(ctx, step, ip, ipInfo); err != nil {
+			name := step.enricher.Name()
+			errs[name] = err
+
+			if ipInfo.Errors == nil {
+				ipInfo.Errors = make(map[string]string)
+			}
+			if texter, ok := step.enricher.(ErrorTexter); ok {
+				ipInfo.Errors[name] = texter.ErrorText(err)
+			} else {
+				ipInfo.Errors[name] = err.Error()
+			}
+		}
+	}
+	return ipInfo, errs
+}
+
+func runEnrichStep(ctx context.Context, step enrichStep, ip net.IP, ipInfo *IPInfo) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, step.timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return step.enricher.Enrich(ctx, ip, ipInfo)
+}
+
+type ClassEnricher struct{}
+
+func (e *ClassEnricher) Name() string {
+	return "class"
+}
+
+func (e *ClassEnricher) Enrich(ctx context.Context, ip net.IP, ipInfo *IPInfo) error {
+	if class := classifyIP(ip); class != nil {
+		ipInfo.Class = class.Name
+		ipInfo.ClassDescription = class.Description
+	}
+	return nil
+}
+
+type GeoEnricher struct {
+	Provider GeoProvider
+}
+
+func (e *GeoEnricher) Name() string {
+	return "geo"
+}
+
+func (e *GeoEnricher) Enrich(ctx context.Context, ip net.IP, ipInfo *IPInfo) error {
+	// Special-purpose addresses have no geolocation, don't spend provider quota on them
+	if class := classifyIP(ip); class != nil && !class.Global {
+		return nil
+	}
+
+	geoInfo, err := getIPInfo(ctx, e.Provider, ip)
+	if err != nil {
+		return err
+	}
+	ipInfo.setGeo(geoInfo)
+	return nil
+}
+
+func (e *GeoEnricher) ErrorText(err error) string {
+	return geoErrorText(err)
+}
+
+type ASNEnricher struct {
+	DB interface {
+		Lookup(ip net.IP) *ASNRecord
+	}
+}
+
+func (e *ASNEnricher) Name() string {
+	return "asn"
+}
+
+func (e *ASNEnricher) Enrich(ctx context.Context, ip net.IP, ipInfo *IPInfo) error {
+	if record := e.DB.Lookup(ip); record != nil {
+		ipInfo.ASN = record.ASN
+		ipInfo.ASOrg = record.Org
+		ipInfo.Prefix = record.Prefix
+	}
+	return nil
+}
+
+type RDNSEnricher struct {
+	Resolver *net.Resolver
+}
+
+func (e *RDNSEnricher) Name() string {
+	return "rdns"
+}
+
+func (e *RDNSEnricher) Enrich(ctx context.Context, ip net.IP, ipInfo *IPInfo) error {
+	hostnames, err := lookupHostnames(ctx, e.Resolver, ip)
+	if err != nil {
+		return err
+	}
+	ipInfo.Hostnames = hostnames
+	return nil
+}
+
+type BlocklistEnricher struct {
+	Blocklists interface {
+		Lookup(ip net.IP) []BlocklistMatch
+	}
+}
+
+func (e *BlocklistEnricher) Name() string {
+	return "blocklists"
+}
+
+func (e *BlocklistEnricher) Enrich(ctx context.Context, ip net.IP, ipInfo *IPInfo) error {
+	ipInfo.Blocklists = e.Blocklists.Lookup(ip)
+	return nil
+}
+
+type CloudEnricher struct {
+	Ranges interface {
+		Lookup(ip net.IP) []CloudMatch
+	}
+}
+
+func (e *CloudEnricher) Name() string {
+	return "cloud"
+}
+
+func (e *CloudEnricher) Enrich(ctx context.Context, ip net.IP, ipInfo *IPInfo) error {
+	ipInfo.Cloud = e.Ranges.Lookup(ip)
+	return nil
+}
+
+type DNSBLEnricher struct {
+	Checker interface {
+		Check(ctx context.Context, ip net.IP) ([]DNSBLResult, error)
+	}
+}
+
+func (e *DNSBLEnricher) Name() string {
+	return "dnsbl"
+}
+
+func (e *DNSBLEnricher) Enrich(ctx context.Context, ip net.IP, ipInfo *IPInfo) error {
+	// Blocklist zones only list public addresses
+	if class := classifyIP(ip); class != nil && !class.Global {
+		return nil
+	}
+
+	results, err := e.Checker.Check(ctx, ip)
+	ipInfo.DNSBL = results
+	return err
+}
+
+type RDAPEnricher struct {
+	Client interface {
+		Lookup(ctx context.Context, ip net.IP) (*OwnerInfo, error)
+	}
+}
+
+func (e *RDAPEnricher) Name() string {
+	return "rdap"
+}
+
+func (e *RDAPEnricher) Enrich(ctx context.Context, ip net.IP, ipInfo *IPInfo) error {
+	// Special-purpose ranges are registered to IANA, nothing useful to show
+	if class := classifyIP(ip); class != nil && !class.Global {
+		return nil
+	}
+
+	owner, err := e.Client.Lookup(ctx, ip)
+	if err != nil {
+		return err
+	}
+	ipInfo.Owner = owner
+	return nil
+}
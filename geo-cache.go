@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+type geoCacheEntry struct {
+	ipInfo    IPInfo
+	expiresAt time.Time
+}
+
+// CachedGeoProvider serves repeated lookups of the same IP from memory and,
+// optionally, from previously stored IP checks
+type CachedGeoProvider struct {
+	Provider GeoProvider
+	TTL      time.Duration
+	Store    interface {
+		GetLatestByIP(ip string, since time.Time) (*IPCheck, error)
+	}
+
+	mu        sync.Mutex
+	entries   map[string]geoCacheEntry
+	lastPurge time.Time
+}
+
+func NewCachedGeoProvider(provider GeoProvider, ttl time.Duration) *CachedGeoProvider {
+	return &CachedGeoProvider{
+		Provider:  provider,
+		TTL:       ttl,
+		entries:   make(map[string]geoCacheEntry),
+		lastPurge: time.Now(),
+	}
+}
+
+func (c *CachedGeoProvider) Name() string {
+	return c.Provider.Name()
+}
+
+func (c *CachedGeoProvider) GetIPInfo(ctx context.Context, ip net.IP) (*IPInfo, error) {
+	key := ip.String()
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		ipInfo := entry.ipInfo
+		return &ipInfo, nil
+	}
+
+	if c.Store != nil {
+		ipCheck, err := c.Store.GetLatestByIP(key, now.Add(-c.TTL))
+		if err == nil && ipCheck != nil {
+			ipInfo := IPInfo{}
+			if err := json.Unmarshal(ipCheck.IPInfo, &ipInfo); err == nil && ipInfo.Provider != "" {
+				c.set(key, ipInfo, ipCheck.CreatedAt.Add(c.TTL))
+				return &ipInfo, nil
+			}
+		}
+	}
+
+	ipInfo, err := c.Provider.GetIPInfo(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	c.set(key, *ipInfo, now.Add(c.TTL))
+	return ipInfo, nil
+}
+
+func (c *CachedGeoProvider) Reload() error {
+	if err := reloadGeoProvider(c.Provider); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.entries = make(map[string]geoCacheEntry)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *CachedGeoProvider) Health() []ProviderHealth {
+	return geoProviderHealth(c.Provider)
+}
+
+func (c *CachedGeoProvider) set(key string, ipInfo IPInfo, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPurge) > c.TTL {
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastPurge = now
+	}
+	c.entries[key] = geoCacheEntry{ipInfo: ipInfo, expiresAt: expiresAt}
+}
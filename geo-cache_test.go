@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// stubGeoProvider answers with a fixed country, or with err if it is set
+type stubGeoProvider struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (p *stubGeoProvider) Name() string {
+	return p.name
+}
+
+func (p *stubGeoProvider) GetIPInfo(ctx context.Context, ip net.IP) (*IPInfo, error) {
+	p.calls++
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &IPInfo{IP: ip.String(), CountryCode: "NL"}, nil
+}
+
+// stubIPCheckStore returns its check like the DB does, if it was created since the given time
+type stubIPCheckStore struct {
+	ipCheck *IPCheck
+}
+
+func (s *stubIPCheckStore) GetLatestByIP(ip string, since time.Time) (*IPCheck, error) {
+	if s.ipCheck == nil || s.ipCheck.IP != ip || s.ipCheck.CreatedAt.Before(since) {
+		return nil, ErrIPCheckNotFound
+	}
+	return s.ipCheck, nil
+}
+
+func TestCachedGeoProviderTTL(t *testing.T) {
+	provider := &stubGeoProvider{name: "stub"}
+	cache := NewCachedGeoProvider(provider, 50*time.Millisecond)
+	ip := net.ParseIP("192.0.2.1")
+
+	for i := 0; i < 3; i++ {
+		ipInfo, err := cache.GetIPInfo(context.Background(), ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ipInfo.CountryCode != "NL" {
+			t.Errorf("GetIPInfo() country = %v, want NL", ipInfo.CountryCode)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider called %v times within TTL, want 1", provider.calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := cache.GetIPInfo(context.Background(), ip); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 2 {
+		t.Errorf("provider called %v times after TTL, want 2", provider.calls)
+	}
+}
+
+func TestCachedGeoProviderStore(t *testing.T) {
+	provider := &stubGeoProvider{name: "stub"}
+	cache := NewCachedGeoProvider(provider, time.Hour)
+	store := &stubIPCheckStore{}
+	cache.Store = store
+	ip := net.ParseIP("192.0.2.1")
+
+	// The stored check expires 50ms from now, as it was made TTL-50ms ago
+	store.ipCheck = &IPCheck{
+		IP:        "192.0.2.1",
+		IPInfo:    []byte(`{"ip": "192.0.2.1", "country_code": "DE", "provider": "ipstack"}`),
+		CreatedAt: time.Now().Add(-time.Hour + 50*time.Millisecond),
+	}
+	ipInfo, err := cache.GetIPInfo(context.Background(), ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipInfo.CountryCode != "DE" || provider.calls != 0 {
+		t.Errorf("GetIPInfo() = %+v with %v provider calls, want the stored check", ipInfo, provider.calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	ipInfo, err = cache.GetIPInfo(context.Background(), ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipInfo.CountryCode != "NL" || provider.calls != 1 {
+		t.Errorf("GetIPInfo() = %+v with %v provider calls, want the provider result after the stored check expired", ipInfo, provider.calls)
+	}
+}
+
+func TestCachedGeoProviderStoreWithoutProvider(t *testing.T) {
+	provider := &stubGeoProvider{name: "stub"}
+	cache := NewCachedGeoProvider(provider, time.Hour)
+	// Checks without geolocation, e.g. of private addresses or failed lookups, have no provider
+	cache.Store = &stubIPCheckStore{ipCheck: &IPCheck{
+		IP:        "192.0.2.1",
+		IPInfo:    []byte(`{"ip": "192.0.2.1", "type": "ipv4"}`),
+		CreatedAt: time.Now(),
+	}}
+
+	ipInfo, err := cache.GetIPInfo(context.Background(), net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipInfo.CountryCode != "NL" || provider.calls != 1 {
+		t.Errorf("GetIPInfo() = %+v with %v provider calls, want the provider result", ipInfo, provider.calls)
+	}
+}
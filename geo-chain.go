@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoGeoProviders = errors.New("all geo providers are unavailable")
+
+// GeoChainError keeps errors of every failed provider, so errors.Is and errors.As
+// can look for a specific provider error
+type GeoChainError struct {
+	Names []string
+	Errs  []error
+}
+
+func (e *GeoChainError) Error() string {
+	if len(e.Errs) == 0 {
+		return ErrNoGeoProviders.Error()
+	}
+	errs := make([]string, 0, len(e.Errs))
+	for i, err := range e.Errs {
+		errs = append(errs, fmt.Sprintf("%v: %v", e.Names[i], err))
+	}
+	return fmt.Sprintf("%v (%v)", ErrNoGeoProviders, strings.Join(errs, "; "))
+}
+
+func (e *GeoChainError) Is(target error) bool {
+	if target == ErrNoGeoProviders {
+		return true
+	}
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *GeoChainError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+type ProviderHealth struct {
+	Name      string     `json:"name"`
+	Healthy   bool       `json:"healthy"`
+	Failures  int        `json:"failures"`
+	LastError string     `json:"last_error,omitempty"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+type HealthReporter interface {
+	Health() []ProviderHealth
+}
+
+func geoProviderHealth(provider GeoProvider) []ProviderHealth {
+	reporter, ok := provider.(HealthReporter)
+	if !ok {
+		return []ProviderHealth{{Name: provider.Name(), Healthy: true}}
+	}
+	return reporter.Health()
+}
+
+// circuitBreaker skips a provider for Cooldown after MaxFailures failures in a row
+type circuitBreaker struct {
+	MaxFailures int
+	Cooldown    time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	lastError error
+	openUntil time.Time
+}
+
+func (cb *circuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.failures < cb.MaxFailures || time.Now().After(cb.openUntil)
+}
+
+func (cb *circuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+	cb.lastError = nil
+	cb.openUntil = time.Time{}
+}
+
+func (cb *circuitBreaker) Failure(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	cb.lastError = err
+	if cb.failures >= cb.MaxFailures {
+		cb.openUntil = time.Now().Add(cb.Cooldown)
+	}
+}
+
+func (cb *circuitBreaker) Health(name string) ProviderHealth {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	health := ProviderHealth{
+		Name:     name,
+		Healthy:  cb.failures < cb.MaxFailures || time.Now().After(cb.openUntil),
+		Failures: cb.failures,
+	}
+	if cb.lastError != nil {
+		health.LastError = cb.lastError.Error()
+	}
+	if !health.Healthy {
+		openUntil := cb.openUntil
+		health.OpenUntil = &openUntil
+	}
+	return health
+}
+
+type chainLink struct {
+	provider GeoProvider
+	breaker  *circuitBreaker
+}
+
+// GeoProviderChain asks providers in order and falls through to the next one on error
+type GeoProviderChain struct {
+	links []chainLink
+}
+
+func NewGeoProviderChain(providers []GeoProvider, maxFailures int, cooldown time.Duration) *GeoProviderChain {
+	chain := &GeoProviderChain{}
+	for _, provider := range providers {
+		chain.links = append(chain.links, chainLink{
+			provider: provider,
+			breaker:  &circuitBreaker{MaxFailures: maxFailures, Cooldown: cooldown},
+		})
+	}
+	return chain
+}
+
+func (c *GeoProviderChain) Name() string {
+	names := make([]string, 0, len(c.links))
+	for _, link := range c.links {
+		names = append(names, link.provider.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *GeoProviderChain) GetIPInfo(ctx context.Context, ip net.IP) (*IPInfo, error) {
+	chainErr := &GeoChainError{}
+	for _, link := range c.links {
+		if !link.breaker.Allow() {
+			continue
+		}
+
+		ipInfo, err := link.provider.GetIPInfo(ctx, ip)
+		if err != nil && ctx.Err() != nil {
+			// The caller gave up, it's not the provider's fault
+			return nil, err
+		}
+		if err != nil {
+			// A provider without data for the address is still healthy
+			if errors.Is(err, ErrGeoNotFound) {
+				link.breaker.Success()
+			} else {
+				link.breaker.Failure(err)
+			}
+			chainErr.Names = append(chainErr.Names, link.provider.Name())
+			chainErr.Errs = append(chainErr.Errs, err)
+			continue
+		}
+
+		link.breaker.Success()
+		if ipInfo.Provider == "" {
+			ipInfo.Provider = link.provider.Name()
+		}
+		return ipInfo, nil
+	}
+
+	return nil, chainErr
+}
+
+func (c *GeoProviderChain) Reload() error {
+	reloaded := false
+	for _, link := range c.links {
+		err := reloadGeoProvider(link.provider)
+		if errors.Is(err, ErrReloadNotSupported) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		reloaded = true
+	}
+	if !reloaded {
+		return ErrReloadNotSupported
+	}
+	return nil
+}
+
+func (c *GeoProviderChain) Health() []ProviderHealth {
+	health := make([]ProviderHealth, 0, len(c.links))
+	for _, link := range c.links {
+		health = append(health, link.breaker.Health(link.provider.Name()))
+	}
+	return health
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGeoProviderChainFallThrough(t *testing.T) {
+	first := &stubGeoProvider{name: "first", err: errors.New("connection refused")}
+	second := &stubGeoProvider{name: "second"}
+	chain := NewGeoProviderChain([]GeoProvider{first, second}, 3, time.Minute)
+
+	ipInfo, err := chain.GetIPInfo(context.Background(), net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipInfo.Provider != "second" || first.calls != 1 || second.calls != 1 {
+		t.Errorf("GetIPInfo() provider = %v, calls %v/%v, want second after one call each", ipInfo.Provider, first.calls, second.calls)
+	}
+
+	health := chain.Health()
+	if health[0].Failures != 1 || health[0].LastError != "connection refused" || !health[1].Healthy {
+		t.Errorf("Health() = %+v", health)
+	}
+}
+
+func TestGeoProviderChainBreaker(t *testing.T) {
+	provider := &stubGeoProvider{name: "stub", err: errors.New("timeout")}
+	chain := NewGeoProviderChain([]GeoProvider{provider}, 2, 50*time.Millisecond)
+	ip := net.ParseIP("192.0.2.1")
+
+	for i := 0; i < 3; i++ {
+		if _, err := chain.GetIPInfo(context.Background(), ip); !errors.Is(err, ErrNoGeoProviders) {
+			t.Fatalf("GetIPInfo() error = %v, want %v", err, ErrNoGeoProviders)
+		}
+	}
+	if provider.calls != 2 {
+		t.Errorf("provider called %v times, want 2 before the breaker opened", provider.calls)
+	}
+	if health := chain.Health()[0]; health.Healthy || health.OpenUntil == nil {
+		t.Errorf("Health() = %+v, want an open breaker", health)
+	}
+
+	// After the cooldown one request is let through, it closes the breaker on success
+	time.Sleep(60 * time.Millisecond)
+	provider.err = nil
+	if _, err := chain.GetIPInfo(context.Background(), ip); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 3 {
+		t.Errorf("provider called %v times, want 3 after the cooldown", provider.calls)
+	}
+	if health := chain.Health()[0]; !health.Healthy || health.Failures != 0 || health.OpenUntil != nil {
+		t.Errorf("Health() = %+v, want a closed breaker", health)
+	}
+}
+
+func TestGeoProviderChainCanceled(t *testing.T) {
+	provider := &stubGeoProvider{name: "stub"}
+	chain := NewGeoProviderChain([]GeoProvider{provider}, 1, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := chain.GetIPInfo(ctx, net.ParseIP("192.0.2.1")); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetIPInfo() error = %v, want %v", err, context.Canceled)
+	}
+	if health := chain.Health()[0]; !health.Healthy || health.Failures != 0 {
+		t.Errorf("Health() = %+v, a canceled request must not count as a failure", health)
+	}
+}
+
+func TestGeoChainErrorIs(t *testing.T) {
+	chain := NewGeoProviderChain([]GeoProvider{
+		&stubGeoProvider{name: "ipstack", err: fmt.Errorf("request failed: %w", ErrIPStackQuotaReached)},
+		&stubGeoProvider{name: "mmdb", err: ErrGeoNotFound},
+	}, 3, time.Minute)
+
+	_, err := chain.GetIPInfo(context.Background(), net.ParseIP("192.0.2.1"))
+	for _, target := range []error{ErrNoGeoProviders, ErrIPStackQuotaReached, ErrGeoNotFound} {
+		if !errors.Is(err, target) {
+			t.Errorf("errors.Is(%v, %v) = false", err, target)
+		}
+	}
+	if errors.Is(err, ErrIPStackInvalidKey) {
+		t.Errorf("errors.Is(%v, %v) = true", err, ErrIPStackInvalidKey)
+	}
+
+	var chainErr *GeoChainError
+	if !errors.As(err, &chainErr) || len(chainErr.Errs) != 2 {
+		t.Errorf("errors.As(%v) = %+v", err, chainErr)
+	}
+}
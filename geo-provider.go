@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+type GeoProvider interface {
+	Name() string
+	GetIPInfo(ctx context.Context, ip net.IP) (*IPInfo, error)
+}
+
+type Reloader interface {
+	Reload() error
+}
+
+var (
+	ErrReloadNotSupported = errors.New("geo provider doesn't support reloading")
+	// ErrGeoNotFound means the provider works, but knows nothing about the address
+	ErrGeoNotFound = errors.New("geo provider has no data for the IP address")
+)
+
+func reloadGeoProvider(provider GeoProvider) error {
+	reloader, ok := provider.(Reloader)
+	if !ok {
+		return ErrReloadNotSupported
+	}
+	return reloader.Reload()
+}
+
+func newGeoProvider(name string, client *HTTPClient) (GeoProvider, error) {
+	switch name {
+	case "", "ipstack":
+		return &IPStackProvider{
+			URL:       os.Getenv("IPSTACK_URL"),
+			AccessKey: os.Getenv("IPSTACK_ACCESS_KEY"),
+			Client:    client,
+		}, nil
+	case "mmdb":
+		return NewMMDBProvider(os.Getenv("MMDB_PATH"))
+	default:
+		return nil, fmt.Errorf("unknown geo provider '%v'", name)
+	}
+}
+
+// newGeoProviderChain wraps even a single provider, so its failures are tracked and reported too
+func newGeoProviderChain(names string, client *HTTPClient, maxFailures int, cooldown time.Duration) (*GeoProviderChain, error) {
+	providers := make([]GeoProvider, 0, 2)
+	for _, name := range strings.Split(names, ",") {
+		provider, err := newGeoProvider(strings.TrimSpace(name), client)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return NewGeoProviderChain(providers, maxFailures, cooldown), nil
+}
+
+func geoErrorText(err error) string {
+	switch {
+	case errors.Is(err, ErrIPStackInvalidIP):
+		return "This IP address can't be checked"
+	case errors.Is(err, ErrIPStackQuotaReached), errors.Is(err, ErrIPStackInvalidKey):
+		return "IP checks are temporarily unavailable, admins are notified\nTry again later"
+	case errors.Is(err, ErrIPStackRateLimited):
+		return "Too many checks right now\nTry again in a minute"
+	case errors.Is(err, ErrGeoNotFound):
+		return "The location of this IP address is unknown"
+	default:
+		return "Can't get information about this IP address right now\nTry again later"
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
 	github.com/gorilla/mux v1.8.0
 	github.com/joho/godotenv v1.4.0
+	github.com/oschwald/maxminddb-golang v1.3.1
 	github.com/sirupsen/logrus v1.8.1
 	gorm.io/datatypes v1.0.2
 	gorm.io/driver/postgres v1.1.2
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return "unexpected response status: " + e.Status
+}
+
+// HTTPClient is shared by the lookups, every attempt gets its own timeout and
+// 5xx/429 responses are retried with exponential backoff
+type HTTPClient struct {
+	Client  *http.Client
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+}
+
+func NewHTTPClient(timeout time.Duration, retries int, backoff time.Duration) *HTTPClient {
+	if retries < 0 {
+		retries = 0
+	}
+	return &HTTPClient{
+		Client:  &http.Client{},
+		Timeout: timeout,
+		Retries: retries,
+		Backoff: backoff,
+	}
+}
+
+func (c *HTTPClient) Do(ctx context.Context, request *http.Request) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%w (last error: %v)", ctx.Err(), lastErr)
+			case <-time.After(c.Backoff << (attempt - 1)):
+			}
+		}
+
+		body, retry, err := c.do(ctx, request)
+		if err == nil {
+			return body, nil
+		}
+		if !retry || ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (c *HTTPClient) do(ctx context.Context, request *http.Request) ([]byte, bool, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	resp, err := c.Client.Do(request.Clone(attemptCtx))
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return body, retry, &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return body, false, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusServer answers with the statuses in order, the last one repeats
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	attempts := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := int(atomic.AddInt32(attempts, 1)) - 1
+		if attempt >= len(statuses) {
+			attempt = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[attempt])
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, attempts
+}
+
+func doGet(t *testing.T, client *HTTPClient, url string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.Do(context.Background(), request)
+}
+
+func TestHTTPClientRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantStatus   int
+		wantAttempts int32
+	}{
+		{name: "success", statuses: []int{200}, wantAttempts: 1},
+		{name: "5xx retried", statuses: []int{503, 502, 200}, wantAttempts: 3},
+		{name: "429 retried", statuses: []int{429, 200}, wantAttempts: 2},
+		{name: "4xx not retried", statuses: []int{404, 200}, wantStatus: 404, wantAttempts: 1},
+		{name: "retries exhausted", statuses: []int{500}, wantStatus: 500, wantAttempts: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, attempts := statusServer(t, tt.statuses...)
+			body, err := doGet(t, NewHTTPClient(time.Second, 2, time.Millisecond), server.URL)
+
+			var statusErr *HTTPStatusError
+			switch {
+			case tt.wantStatus == 0 && (err != nil || string(body) != "ok"):
+				t.Errorf("Do() = %q, %v, want ok", body, err)
+			case tt.wantStatus != 0 && (!errors.As(err, &statusErr) || statusErr.StatusCode != tt.wantStatus):
+				t.Errorf("Do() error = %v, want status %v", err, tt.wantStatus)
+			}
+			if *attempts != tt.wantAttempts {
+				t.Errorf("server got %v attempts, want %v", *attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestHTTPClientBackoff(t *testing.T) {
+	server, _ := statusServer(t, 503, 503, 200)
+	start := time.Now()
+	if _, err := doGet(t, NewHTTPClient(time.Second, 2, 30*time.Millisecond), server.URL); err != nil {
+		t.Fatal(err)
+	}
+	// The pause doubles: 30ms, then 60ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Do() took %v, want at least 90ms of backoff", elapsed)
+	}
+}
+
+func TestHTTPClientAttemptTimeout(t *testing.T) {
+	attempts := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(attempts, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	body, err := doGet(t, NewHTTPClient(50*time.Millisecond, 1, time.Millisecond), server.URL)
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("Do() = %q, %v, want ok from the second attempt", body, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Do() took %v, the hung attempt wasn't timed out", elapsed)
+	}
+}
+
+func TestNewHTTPClientNegativeRetries(t *testing.T) {
+	client := NewHTTPClient(time.Second, -1, 0)
+	if client.Retries != 0 {
+		t.Errorf("Retries = %v, want 0", client.Retries)
+	}
+
+	server, attempts := statusServer(t, 200)
+	if body, err := doGet(t, client, server.URL); err != nil || string(body) != "ok" {
+		t.Errorf("Do() = %q, %v, want ok", body, err)
+	}
+	if *attempts != 1 {
+		t.Errorf("server got %v attempts, want 1", *attempts)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"sort"
+)
+
+type IPClass struct {
+	Name        string
+	Description string
+	Network     *net.IPNet
+	// Global ranges are routable on the Internet, so geolocation still makes sense
+	Global bool
+}
+
+// Special-purpose ranges from the IANA IPv4/IPv6 special-purpose address registries
+var ipClasses = func() []IPClass {
+	ranges := []struct {
+		cidr, name, description string
+		global                  bool
+	}{
+		{"0.0.0.0/8", "this-network", "\"This network\" (RFC 791)", false},
+		{"10.0.0.0/8", "private", "Private network (RFC 1918)", false},
+		{"100.64.0.0/10", "cgnat", "Shared address space, carrier-grade NAT (RFC 6598)", false},
+		{"127.0.0.0/8", "loopback", "Loopback (RFC 1122)", false},
+		{"169.254.0.0/16", "link-local", "Link-local (RFC 3927)", false},
+		{"172.16.0.0/12", "private", "Private network (RFC 1918)", false},
+		{"192.0.0.0/24", "ietf-protocol", "IETF protocol assignments (RFC 6890)", false},
+		{"192.0.0.0/29", "ietf-protocol", "IPv4 service continuity prefix (RFC 7335)", false},
+		{"192.0.0.8/32", "ietf-protocol", "IPv4 dummy address (RFC 7600)", false},
+		{"192.0.0.9/32", "ietf-protocol", "Port Control Protocol anycast (RFC 7723)", true},
+		{"192.0.0.10/32", "ietf-protocol", "Traversal Using Relays around NAT anycast (RFC 8155)", true},
+		{"192.0.0.170/31", "ietf-protocol", "NAT64/DNS64 discovery (RFC 7050)", false},
+		{"192.0.2.0/24", "documentation", "Documentation, TEST-NET-1 (RFC 5737)", false},
+		{"192.31.196.0/24", "ietf-protocol", "AS112-v4 (RFC 7535)", true},
+		{"192.52.193.0/24", "ietf-protocol", "AMT (RFC 7450)", true},
+		{"192.88.99.0/24", "reserved", "Deprecated 6to4 relay anycast (RFC 7526)", false},
+		{"192.168.0.0/16", "private", "Private network (RFC 1918)", false},
+		{"192.175.48.0/24", "ietf-protocol", "Direct delegation AS112 service (RFC 7534)", true},
+		{"198.18.0.0/15", "benchmarking", "Benchmarking (RFC 2544)", false},
+		{"198.51.100.0/24", "documentation", "Documentation, TEST-NET-2 (RFC 5737)", false},
+		{"203.0.113.0/24", "documentation", "Documentation, TEST-NET-3 (RFC 5737)", false},
+		{"224.0.0.0/4", "multicast", "Multicast (RFC 5771)", false},
+		{"240.0.0.0/4", "reserved", "Reserved for future use (RFC 1112)", false},
+		{"255.255.255.255/32", "broadcast", "Limited broadcast (RFC 919)", false},
+
+		{"::/128", "unspecified", "Unspecified address (RFC 4291)", false},
+		{"::1/128", "loopback", "Loopback (RFC 4291)", false},
+		{"64:ff9b::/96", "nat64", "IPv4-IPv6 translation (RFC 6052)", true},
+		{"64:ff9b:1::/48", "nat64", "Local-use IPv4-IPv6 translation (RFC 8215)", false},
+		{"100::/64", "discard", "Discard-only (RFC 6666)", false},
+		{"2001::/23", "ietf-protocol", "IETF protocol assignments (RFC 2928)", false},
+		{"2001::/32", "teredo", "Teredo (RFC 4380)", true},
+		{"2001:2::/48", "benchmarking", "Benchmarking (RFC 5180)", false},
+		{"2001:10::/28", "reserved", "Deprecated ORCHID (RFC 4843)", false},
+		{"2001:20::/28", "ietf-protocol", "ORCHIDv2 (RFC 7343)", true},
+		{"2001:db8::/32", "documentation", "Documentation (RFC 3849)", false},
+		{"2002::/16", "6to4", "6to4 (RFC 3056)", true},
+		{"3fff::/20", "documentation", "Documentation (RFC 9637)", false},
+		{"5f00::/16", "ietf-protocol", "Segment Routing SIDs (RFC 9602)", false},
+		{"fc00::/7", "unique-local", "Unique local address (RFC 4193)", false},
+		{"fe80::/10", "link-local", "Link-local unicast (RFC 4291)", false},
+		{"fec0::/10", "reserved", "Deprecated site-local (RFC 3879)", false},
+		{"ff00::/8", "multicast", "Multicast (RFC 4291)", false},
+	}
+
+	classes := make([]IPClass, 0, len(ranges))
+	for _, r := range ranges {
+		_, network, err := net.ParseCIDR(r.cidr)
+		if err != nil {
+			panic(err)
+		}
+		classes = append(classes, IPClass{Name: r.name, Description: r.description, Network: network, Global: r.global})
+	}
+
+	// The most specific range wins
+	sort.SliceStable(classes, func(i, j int) bool {
+		iOnes, _ := classes[i].Network.Mask.Size()
+		jOnes, _ := classes[j].Network.Mask.Size()
+		return iOnes > jOnes
+	})
+	return classes
+}()
+
+// classifyIP returns the special-purpose class of the address or nil for a public address
+func classifyIP(ip net.IP) *IPClass {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for i := range ipClasses {
+		if len(ipClasses[i].Network.IP) == len(ip) && ipClasses[i].Network.Contains(ip) {
+			return &ipClasses[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClassifyIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		class  string
+		global bool
+	}{
+		{ip: "10.1.2.3", class: "private"},
+		{ip: "172.31.255.255", class: "private"},
+		{ip: "192.168.0.1", class: "private"},
+		{ip: "127.0.0.1", class: "loopback"},
+		{ip: "100.64.0.1", class: "cgnat"},
+		{ip: "169.254.1.1", class: "link-local"},
+		{ip: "192.0.0.9", class: "ietf-protocol", global: true},
+		{ip: "192.0.0.1", class: "ietf-protocol"},
+		{ip: "192.0.2.1", class: "documentation"},
+		{ip: "224.0.0.251", class: "multicast"},
+		{ip: "255.255.255.255", class: "broadcast"},
+		{ip: "::1", class: "loopback"},
+		{ip: "::", class: "unspecified"},
+		{ip: "fe80::1", class: "link-local"},
+		{ip: "fd00::1", class: "unique-local"},
+		{ip: "2001:db8::1", class: "documentation"},
+		{ip: "2001::1", class: "teredo", global: true},
+		{ip: "::ffff:10.0.0.1", class: "private"},
+		{ip: "8.8.8.8"},
+		{ip: "2001:4860:4860::8888"},
+		{ip: "172.32.0.1"},
+	}
+
+	for _, tt := range tests {
+		class := classifyIP(net.ParseIP(tt.ip))
+		if tt.class == "" {
+			if class != nil {
+				t.Errorf("classifyIP(%v) = %v, want public address", tt.ip, class.Name)
+			}
+			continue
+		}
+		if class == nil {
+			t.Errorf("classifyIP(%v) = nil, want %v", tt.ip, tt.class)
+			continue
+		}
+		if class.Name != tt.class || class.Global != tt.global {
+			t.Errorf("classifyIP(%v) = %v (global %v), want %v (global %v)", tt.ip, class.Name, class.Global, tt.class, tt.global)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+var ErrIPCheckDiffMismatch = errors.New("checks are of different addresses")
+
+// IPCheckDiff is the difference between two stored checks of the same address
+type IPCheckDiff struct {
+	IP       string        `json:"ip"`
+	FromID   int           `json:"from_id"`
+	ToID     int           `json:"to_id"`
+	FromTime time.Time     `json:"from_time"`
+	ToTime   time.Time     `json:"to_time"`
+	Changes  []FieldChange `json:"changes"`
+}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// diffIPInfo returns the fields which differ between two checks of the same address.
+// Fields of a step which failed in either check are skipped, a failure isn't a change
+func diffIPInfo(old, new *IPInfo) []FieldChange {
+	fields := []struct {
+		name     string
+		enricher string
+		value    func(ip *IPInfo) string
+	}{
+		{"Class", "class", func(ip *IPInfo) string { return ip.ClassDescription }},
+		{"Country", "geo", func(ip *IPInfo) string { return ip.CountryName }},
+		{"Region", "geo", func(ip *IPInfo) string { return ip.RegionName }},
+		{"City", "geo", func(ip *IPInfo) string { return ip.City }},
+		{"Coordinates", "geo", func(ip *IPInfo) string {
+			if ip.Latitude == 0 && ip.Longitude == 0 {
+				return ""
+			}
+			return fmt.Sprintf("%.4f, %.4f", ip.Latitude, ip.Longitude)
+		}},
+		{"ASN", "asn", func(ip *IPInfo) string {
+			if ip.ASN == 0 {
+				return ""
+			}
+			return fmt.Sprintf("AS%v %v", ip.ASN, ip.ASOrg)
+		}},
+		{"Prefix", "asn", func(ip *IPInfo) string { return ip.Prefix }},
+		{"Owner", "rdap", func(ip *IPInfo) string {
+			if ip.Owner == nil {
+				return ""
+			}
+			return strings.TrimSpace(ip.Owner.Org + " " + ip.Owner.CIDR)
+		}},
+		{"Hosting", "cloud", func(ip *IPInfo) string {
+			ranges := make([]string, 0, len(ip.Cloud))
+			for _, match := range ip.Cloud {
+				ranges = append(ranges, strings.TrimSpace(strings.Join([]string{match.Provider, match.Service, match.Region}, " ")))
+			}
+			return strings.Join(ranges, "; ")
+		}},
+		{"Blocklists", "blocklists", func(ip *IPInfo) string {
+			lists := make([]string, 0, len(ip.Blocklists))
+			for _, match := range ip.Blocklists {
+				lists = append(lists, match.List)
+			}
+			return strings.Join(lists, ", ")
+		}},
+		{"DNSBL", "dnsbl", func(ip *IPInfo) string {
+			zones := make([]string, 0, len(ip.DNSBL))
+			for _, result := range ip.DNSBL {
+				if result.Listed {
+					zones = append(zones, result.Zone)
+				}
+			}
+			return strings.Join(zones, ", ")
+		}},
+		{"Hostnames", "rdns", func(ip *IPInfo) string { return strings.Join(ip.Hostnames, ", ") }},
+	}
+
+	changes := make([]FieldChange, 0)
+	for _, field := range fields {
+		if old.Errors[field.enricher] != "" || new.Errors[field.enricher] != "" {
+			continue
+		}
+		oldValue, newValue := field.value(old), field.value(new)
+		if oldValue != newValue {
+			changes = append(changes, FieldChange{Field: field.name, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+func diffMessageString(changes []FieldChange) string {
+	lines := make([]string, 0, len(changes))
+	for _, change := range changes {
+		oldValue, newValue := change.Old, change.New
+		if oldValue == "" {
+			oldValue = "—"
+		}
+		if newValue == "" {
+			newValue = "—"
+		}
+		lines = append(lines, fmt.Sprintf("<code>%v:</code> %v → %v", change.Field, html.EscapeString(oldValue), html.EscapeString(newValue)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func diffIPChecks(from, to *IPCheck) (*IPCheckDiff, error) {
+	if from.IP != to.IP {
+		return nil, ErrIPCheckDiffMismatch
+	}
+
+	fromInfo, toInfo := IPInfo{}, IPInfo{}
+	if err := json.Unmarshal(from.IPInfo, &fromInfo); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to.IPInfo, &toInfo); err != nil {
+		return nil, err
+	}
+	return &IPCheckDiff{
+		IP:       to.IP,
+		FromID:   from.ID,
+		ToID:     to.ID,
+		FromTime: from.CreatedAt,
+		ToTime:   to.CreatedAt,
+		Changes:  diffIPInfo(&fromInfo, &toInfo),
+	}, nil
+}
+
+func (diff *IPCheckDiff) MessageString() string {
+	title := "Changes since " + diff.FromTime.UTC().Format("2006-01-02 15:04 UTC")
+	if len(diff.Changes) == 0 {
+		return messageSection(title, "Nothing has changed")
+	}
+	return messageSection(title, diffMessageString(diff.Changes))
+}
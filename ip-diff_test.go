@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffIPInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		old  IPInfo
+		new  IPInfo
+		want []FieldChange
+	}{
+		{
+			name: "nothing changed",
+			old:  IPInfo{CountryName: "Netherlands", ASN: 64500, ASOrg: "EXAMPLE"},
+			new:  IPInfo{CountryName: "Netherlands", ASN: 64500, ASOrg: "EXAMPLE"},
+			want: []FieldChange{},
+		},
+		{
+			name: "changed fields",
+			old:  IPInfo{CountryName: "Netherlands", City: "Amsterdam", ASN: 64500, ASOrg: "EXAMPLE"},
+			new:  IPInfo{CountryName: "Germany", ASN: 64501, ASOrg: "OTHER", Hostnames: []string{"host.example.org"}},
+			want: []FieldChange{
+				{Field: "Country", Old: "Netherlands", New: "Germany"},
+				{Field: "City", Old: "Amsterdam", New: ""},
+				{Field: "ASN", Old: "AS64500 EXAMPLE", New: "AS64501 OTHER"},
+				{Field: "Hostnames", Old: "", New: "host.example.org"},
+			},
+		},
+		{
+			name: "failed step skipped",
+			old:  IPInfo{CountryName: "Netherlands", ASN: 64500, ASOrg: "EXAMPLE"},
+			new:  IPInfo{ASN: 64501, ASOrg: "OTHER", Errors: map[string]string{"geo": "quota reached"}},
+			want: []FieldChange{{Field: "ASN", Old: "AS64500 EXAMPLE", New: "AS64501 OTHER"}},
+		},
+		{
+			name: "step failed before",
+			old:  IPInfo{Errors: map[string]string{"asn": "no database"}},
+			new:  IPInfo{ASN: 64501, ASOrg: "OTHER"},
+			want: []FieldChange{},
+		},
+		{
+			name: "listings",
+			old:  IPInfo{DNSBL: []DNSBLResult{{Zone: "zen.example.org"}}},
+			new: IPInfo{
+				DNSBL:      []DNSBLResult{{Zone: "zen.example.org", Listed: true}},
+				Blocklists: []BlocklistMatch{{List: "firehol_level1", Prefix: "192.0.2.0/24"}},
+			},
+			want: []FieldChange{
+				{Field: "Blocklists", Old: "", New: "firehol_level1"},
+				{Field: "DNSBL", Old: "", New: "zen.example.org"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := diffIPInfo(&tt.old, &tt.new); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: diffIPInfo() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffIPChecks(t *testing.T) {
+	from := &IPCheck{
+		ID:        1,
+		IP:        "192.0.2.1",
+		IPInfo:    []byte(`{"ip": "192.0.2.1", "country_name": "Netherlands"}`),
+		CreatedAt: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+	}
+	to := &IPCheck{
+		ID:        2,
+		IP:        "192.0.2.1",
+		IPInfo:    []byte(`{"ip": "192.0.2.1", "country_name": "Germany"}`),
+		CreatedAt: time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC),
+	}
+
+	diff, err := diffIPChecks(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &IPCheckDiff{
+		IP:       "192.0.2.1",
+		FromID:   1,
+		ToID:     2,
+		FromTime: from.CreatedAt,
+		ToTime:   to.CreatedAt,
+		Changes:  []FieldChange{{Field: "Country", Old: "Netherlands", New: "Germany"}},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diffIPChecks() = %+v, want %+v", diff, want)
+	}
+
+	other := &IPCheck{ID: 3, IP: "192.0.2.2", IPInfo: []byte(`{"ip": "192.0.2.2"}`)}
+	if _, err := diffIPChecks(from, other); !errors.Is(err, ErrIPCheckDiffMismatch) {
+		t.Errorf("diffIPChecks() of different IPs error = %v, want %v", err, ErrIPCheckDiffMismatch)
+	}
+
+	broken := &IPCheck{ID: 4, IP: "192.0.2.1", IPInfo: []byte(`{`)}
+	if _, err := diffIPChecks(from, broken); err == nil {
+		t.Error("diffIPChecks() of a broken check should fail")
+	}
+}
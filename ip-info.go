@@ -1,27 +1,42 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
+	"html"
 	"net"
-	"net/http"
-	"os"
+	"sort"
+	"strings"
 )
 
 type IPInfo struct {
-	IP            string  `json:"ip"`
-	Type          string  `json:"type"`
-	ContinentCode string  `json:"continent_code,omitempty"`
-	ContinentName string  `json:"continent_name,omitempty"`
-	CountryCode   string  `json:"country_code,omitempty"`
-	CountryName   string  `json:"country_name,omitempty"`
-	RegionCode    string  `json:"region_code,omitempty"`
-	RegionName    string  `json:"region_name,omitempty"`
-	City          string  `json:"city,omitempty"`
-	Zip           string  `json:"zip,omitempty"`
-	Latitude      float64 `json:"latitude,omitempty"`
-	Longitude     float64 `json:"longitude,omitempty"`
-	Location      struct {
+	IP               string            `json:"ip"`
+	Type             string            `json:"type"`
+	ContinentCode    string            `json:"continent_code,omitempty"`
+	ContinentName    string            `json:"continent_name,omitempty"`
+	CountryCode      string            `json:"country_code,omitempty"`
+	CountryName      string            `json:"country_name,omitempty"`
+	RegionCode       string            `json:"region_code,omitempty"`
+	RegionName       string            `json:"region_name,omitempty"`
+	City             string            `json:"city,omitempty"`
+	Zip              string            `json:"zip,omitempty"`
+	Latitude         float64           `json:"latitude,omitempty"`
+	Longitude        float64           `json:"longitude,omitempty"`
+	Provider         string            `json:"provider,omitempty"`
+	Hostnames        []string          `json:"hostnames,omitempty"`
+	Class            string            `json:"class,omitempty"`
+	ClassDescription string            `json:"class_description,omitempty"`
+	Subnet           *SubnetInfo       `json:"subnet,omitempty"`
+	ASN              int               `json:"asn,omitempty"`
+	ASOrg            string            `json:"as_org,omitempty"`
+	Prefix           string            `json:"prefix,omitempty"`
+	Errors           map[string]string `json:"errors,omitempty"`
+	Blocklists       []BlocklistMatch  `json:"blocklists,omitempty"`
+	Cloud            []CloudMatch      `json:"cloud,omitempty"`
+	DNSBL            []DNSBLResult     `json:"dnsbl,omitempty"`
+	Owner            *OwnerInfo        `json:"owner,omitempty"`
+	Location         struct {
 		GeonameID int    `json:"geoname_id,omitempty"`
 		Capital   string `json:"capital,omitempty"`
 		Languages []struct {
@@ -48,44 +63,132 @@ func (ip *IPInfo) JSONBytes() []byte {
 }
 
 func (ip *IPInfo) MessageString() string {
-	message := "<code>IP:</code> " + ip.IP
+	message := ""
+	if ip.Subnet != nil {
+		message = ip.Subnet.MessageString() + "\n\n"
+	}
+	message += "<code>IP:</code> " + ip.IP
 	message += "\n<code>Type:</code> " + ip.Type
-	message += "\n<code>Continent:</code> " + ip.ContinentName
-	message += "\n<code>Country:</code> " + ip.CountryName + " " + ip.Location.CountryFlagEmoji
-	message += "\n<code>Region:</code> " + ip.RegionName
-	message += "\n<code>City:</code> " + ip.City
-	return message
-}
+	if ip.Class != "" {
+		message += "\n<code>Class:</code> " + ip.ClassDescription
+	}
 
-func getIPInfo(ip net.IP) (*IPInfo, error) {
-	request, err := http.NewRequest(http.MethodGet, os.Getenv("IPSTACK_URL")+ip.String(), nil)
-	if err != nil {
-		return nil, err
+	// Checks stored before the enrichment pipeline have neither provider nor errors
+	if ip.Provider != "" || (ip.Class == "" && ip.Errors["geo"] == "") {
+		geo := "<code>Continent:</code> " + ip.ContinentName
+		geo += "\n<code>Country:</code> " + ip.CountryName + " " + ip.Location.CountryFlagEmoji
+		geo += "\n<code>Region:</code> " + ip.RegionName
+		geo += "\n<code>City:</code> " + ip.City
+		message += messageSection("Geo", geo)
+	}
+	if ip.ASN != 0 {
+		network := fmt.Sprintf("<code>ASN:</code> AS%v %v", ip.ASN, html.EscapeString(ip.ASOrg))
+		network += "\n<code>Prefix:</code> " + ip.Prefix
+		message += messageSection("Network", network)
 	}
+	if ip.Owner != nil {
+		owner := "<code>Network:</code> " + html.EscapeString(ip.Owner.Name)
+		if ip.Owner.Handle != "" {
+			owner += " (" + html.EscapeString(ip.Owner.Handle) + ")"
+		}
+		owner += "\n<code>CIDR:</code> " + ip.Owner.CIDR
+		owner += "\n<code>Organization:</code> " + html.EscapeString(ip.Owner.Org)
+		owner += "\n<code>Abuse:</code> " + html.EscapeString(ip.Owner.AbuseEmail)
+		owner += "\n<code>Registry:</code> " + html.EscapeString(ip.Owner.Registry)
+		message += messageSection("Owner", owner)
+	}
+	if len(ip.Cloud) > 0 {
+		ranges := make([]string, 0, len(ip.Cloud))
+		for _, match := range ip.Cloud {
+			text := "<code>" + match.Provider + ":</code>"
+			for _, field := range []string{match.Service, match.Region} {
+				if field != "" {
+					text += " " + html.EscapeString(field)
+				}
+			}
+			ranges = append(ranges, text+" ("+match.Prefix+")")
+		}
+		message += messageSection("Hosting", strings.Join(ranges, "\n"))
+	}
+	if len(ip.Blocklists) > 0 {
+		lists := make([]string, 0, len(ip.Blocklists))
+		for _, match := range ip.Blocklists {
+			lists = append(lists, fmt.Sprintf("<code>%v:</code> %v", html.EscapeString(match.List), match.Prefix))
+		}
+		message += messageSection("Blocklists", strings.Join(lists, "\n"))
+	}
+	if len(ip.DNSBL) > 0 {
+		zones := make([]string, 0, len(ip.DNSBL))
+		for _, result := range ip.DNSBL {
+			status := "not listed"
+			if result.Listed {
+				status = "listed " + strings.Join(result.Codes, ", ")
+			}
+			zones = append(zones, fmt.Sprintf("<code>%v:</code> %v", html.EscapeString(result.Zone), status))
+		}
+		message += messageSection("DNSBL", strings.Join(zones, "\n"))
+	}
+	if len(ip.Hostnames) > 0 {
+		message += messageSection("Reverse DNS", "<code>Hostnames:</code> "+html.EscapeString(strings.Join(ip.Hostnames, ", ")))
+	}
+	if len(ip.Errors) > 0 {
+		names := make([]string, 0, len(ip.Errors))
+		for name := range ip.Errors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
 
-	query := request.URL.Query()
-	query.Add("access_key", os.Getenv("IPSTACK_ACCESS_KEY"))
-	request.URL.RawQuery = query.Encode()
+		errs := make([]string, 0, len(names))
+		for _, name := range names {
+			errs = append(errs, fmt.Sprintf("<code>%v:</code> %v", name, html.EscapeString(ip.Errors[name])))
+		}
+		message += messageSection("Failed checks", strings.Join(errs, "\n"))
+	}
+	return message
+}
 
-	client := http.Client{}
+func messageSection(title, body string) string {
+	return "\n\n<b>" + title + "</b>\n" + body
+}
 
-	resp, err := client.Do(request)
-	if err != nil {
-		return nil, err
-	}
+// setGeo copies the geolocation fields of a provider result
+func (ip *IPInfo) setGeo(geo *IPInfo) {
+	ip.ContinentCode = geo.ContinentCode
+	ip.ContinentName = geo.ContinentName
+	ip.CountryCode = geo.CountryCode
+	ip.CountryName = geo.CountryName
+	ip.RegionCode = geo.RegionCode
+	ip.RegionName = geo.RegionName
+	ip.City = geo.City
+	ip.Zip = geo.Zip
+	ip.Latitude = geo.Latitude
+	ip.Longitude = geo.Longitude
+	ip.Provider = geo.Provider
+	ip.Location = geo.Location
+}
 
-	responseBytes, err := ioutil.ReadAll(resp.Body)
+func getIPInfo(ctx context.Context, provider GeoProvider, ip net.IP) (*IPInfo, error) {
+	ipInfo, err := provider.GetIPInfo(ctx, ip)
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-
-	IPData := IPInfo{}
-	err = json.Unmarshal(responseBytes, &IPData)
-	if err != nil {
-		return nil, err
+	// Providers may omit the basic fields, fill them from the request
+	if ipInfo.IP == "" {
+		ipInfo.IP = ip.String()
+	}
+	if ipInfo.Type == "" {
+		ipInfo.Type = ipType(ip)
 	}
+	if ipInfo.Provider == "" {
+		ipInfo.Provider = provider.Name()
+	}
+	return ipInfo, nil
+}
 
-	return &IPData, nil
+func ipType(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const resolveTimeout = 5 * time.Second
+
+var (
+	ErrInvalidQuery = errors.New("not an IP address, subnet, hostname or URL")
+	ErrHostNotFound = errors.New("hostname has no A/AAAA records")
+)
+
+func newResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// CheckQuery is a parsed "Check IP" request, exactly one of the fields is set
+type CheckQuery struct {
+	IP      net.IP
+	Network *net.IPNet
+	Host    string
+}
+
+// parseQuery accepts an IP address, a CIDR subnet, a hostname or a URL
+func parseQuery(text string) (*CheckQuery, error) {
+	text = strings.TrimSpace(text)
+	if ip := net.ParseIP(text); ip != nil {
+		return &CheckQuery{IP: ip}, nil
+	}
+	if _, network, err := net.ParseCIDR(text); err == nil {
+		return &CheckQuery{Network: network}, nil
+	}
+
+	host := text
+	if strings.Contains(text, "://") {
+		u, err := url.Parse(text)
+		if err != nil {
+			return nil, ErrInvalidQuery
+		}
+		host = u.Hostname()
+	} else {
+		if i := strings.IndexAny(host, "/?#"); i >= 0 {
+			host = host[:i]
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+
+	host = strings.Trim(host, "[]")
+	if ip := net.ParseIP(host); ip != nil {
+		return &CheckQuery{IP: ip}, nil
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if !isHostname(host) {
+		return nil, ErrInvalidQuery
+	}
+	return &CheckQuery{Host: host}, nil
+}
+
+func isHostname(host string) bool {
+	if len(host) == 0 || len(host) > 253 || !strings.Contains(host, ".") {
+		return false
+	}
+	labels := strings.Split(host, ".")
+	// Top-level domains aren't numeric, so a malformed IP address like 300.1.1.1 isn't a hostname
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func resolveHost(ctx context.Context, resolver *net.Resolver, host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, ErrHostNotFound
+		}
+		return nil, err
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if !seen[addr.IP.String()] {
+			seen[addr.IP.String()] = true
+			ips = append(ips, addr.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, ErrHostNotFound
+	}
+	return ips, nil
+}
+
+// lookupHostnames returns PTR records of the address, no records is not an error
+func lookupHostnames(ctx context.Context, resolver *net.Resolver, ip net.IP) ([]string, error) {
+	names, err := resolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	hostnames := make([]string, 0, len(names))
+	for _, name := range names {
+		hostnames = append(hostnames, strings.TrimSuffix(name, "."))
+	}
+	return hostnames, nil
+}
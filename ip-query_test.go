@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		text    string
+		ip      string
+		network string
+		host    string
+		err     error
+	}{
+		{text: "8.8.8.8", ip: "8.8.8.8"},
+		{text: "  2001:4860:4860::8888 \n", ip: "2001:4860:4860::8888"},
+		{text: "192.0.2.0/24", network: "192.0.2.0/24"},
+		{text: "192.0.2.15/24", network: "192.0.2.0/24"},
+		{text: "2001:db8::/32", network: "2001:db8::/32"},
+		{text: "example.com", host: "example.com"},
+		{text: "Example.COM.", host: "example.com"},
+		{text: "example.com:8080", host: "example.com"},
+		{text: "example.com/path?q=1", host: "example.com"},
+		{text: "https://sub.example.com/path", host: "sub.example.com"},
+		{text: "http://1.2.3.4:8080/", ip: "1.2.3.4"},
+		{text: "http://[2001:db8::1]:443/", ip: "2001:db8::1"},
+		{text: "[2001:db8::1]:443", ip: "2001:db8::1"},
+		{text: "1.2.3.4:80", ip: "1.2.3.4"},
+		{text: "localhost", err: ErrInvalidQuery},
+		{text: "", err: ErrInvalidQuery},
+		{text: "not a host.com", err: ErrInvalidQuery},
+		{text: "-bad.example.com", err: ErrInvalidQuery},
+		{text: "300.1.1.1/8", err: ErrInvalidQuery},
+	}
+
+	for _, tt := range tests {
+		query, err := parseQuery(tt.text)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("parseQuery(%q) error = %v, want %v", tt.text, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseQuery(%q) unexpected error: %v", tt.text, err)
+			continue
+		}
+
+		switch {
+		case tt.ip != "":
+			if !query.IP.Equal(net.ParseIP(tt.ip)) {
+				t.Errorf("parseQuery(%q).IP = %v, want %v", tt.text, query.IP, tt.ip)
+			}
+		case tt.network != "":
+			if query.Network == nil || query.Network.String() != tt.network {
+				t.Errorf("parseQuery(%q).Network = %v, want %v", tt.text, query.Network, tt.network)
+			}
+		default:
+			if query.Host != tt.host {
+				t.Errorf("parseQuery(%q).Host = %q, want %q", tt.text, query.Host, tt.host)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import "net"
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	values   []interface{}
+}
+
+// ipTrie is a binary prefix tree, lookups cost at most 32/128 steps
+// however many prefixes it holds
+type ipTrie struct {
+	root4 ipTrieNode
+	root6 ipTrieNode
+}
+
+func ipTrieKey(ip net.IP) (net.IP, bool) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, true
+	}
+	return ip.To16(), false
+}
+
+func (t *ipTrie) Insert(network *net.IPNet, value interface{}) {
+	ip, isV4 := ipTrieKey(network.IP)
+	if ip == nil {
+		return
+	}
+	ones, bits := network.Mask.Size()
+	// IPv4 network written in IPv6 notation, e.g. ::ffff:10.0.0.0/104
+	if isV4 && bits == 128 {
+		ones -= 96
+	}
+
+	node := &t.root6
+	if isV4 {
+		node = &t.root4
+	}
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> (7 - uint(i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.values = append(node.values, value)
+}
+
+// Lookup returns the values of every prefix containing ip, the least specific first
+func (t *ipTrie) Lookup(ip net.IP) []interface{} {
+	ip, isV4 := ipTrieKey(ip)
+	if ip == nil {
+		return nil
+	}
+
+	node := &t.root6
+	if isV4 {
+		node = &t.root4
+	}
+	values := append([]interface{}{}, node.values...)
+	for i := 0; i < len(ip)*8; i++ {
+		node = node.children[ip[i/8]>>(7-uint(i%8))&1]
+		if node == nil {
+			break
+		}
+		values = append(values, node.values...)
+	}
+	return values
+}
+
+// parseIPOrCIDR accepts both a single address and a network
+func parseIPOrCIDR(text string) (*net.IPNet, bool) {
+	if _, network, err := net.ParseCIDR(text); err == nil {
+		return network, true
+	}
+	ip := net.ParseIP(text)
+	if ip == nil {
+		return nil, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, true
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, true
+}
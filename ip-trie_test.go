@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestIPTrieLookup(t *testing.T) {
+	trie := &ipTrie{}
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32", "::ffff:192.168.0.0/112", "2001:db8::/32", "0.0.0.0/0"} {
+		network, ok := parseIPOrCIDR(cidr)
+		if !ok {
+			t.Fatalf("parseIPOrCIDR(%v) failed", cidr)
+		}
+		trie.Insert(network, cidr)
+	}
+
+	tests := []struct {
+		ip   string
+		want []interface{}
+	}{
+		{ip: "10.1.2.3", want: []interface{}{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32"}},
+		{ip: "10.1.2.4", want: []interface{}{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16"}},
+		{ip: "10.2.0.1", want: []interface{}{"0.0.0.0/0", "10.0.0.0/8"}},
+		// IPv4 network written in IPv6 notation matches IPv4 addresses
+		{ip: "192.168.10.1", want: []interface{}{"0.0.0.0/0", "::ffff:192.168.0.0/112"}},
+		{ip: "::ffff:192.168.10.1", want: []interface{}{"0.0.0.0/0", "::ffff:192.168.0.0/112"}},
+		{ip: "2001:db8::1", want: []interface{}{"2001:db8::/32"}},
+		{ip: "2001:db9::1", want: []interface{}{}},
+	}
+
+	for _, tt := range tests {
+		if got := trie.Lookup(net.ParseIP(tt.ip)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lookup(%v) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestParseIPOrCIDR(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		ok   bool
+	}{
+		{text: "192.0.2.1", want: "192.0.2.1/32", ok: true},
+		{text: "192.0.2.77/24", want: "192.0.2.0/24", ok: true},
+		{text: "2001:db8::1", want: "2001:db8::1/128", ok: true},
+		{text: "2001:db8::/48", want: "2001:db8::/48", ok: true},
+		{text: "192.0.2.1/33"},
+		{text: "example.org"},
+		{text: ""},
+	}
+
+	for _, tt := range tests {
+		network, ok := parseIPOrCIDR(tt.text)
+		if ok != tt.ok || ok && network.String() != tt.want {
+			t.Errorf("parseIPOrCIDR(%q) = %v, %v, want %v, %v", tt.text, network, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+var (
+	ErrIPStackInvalidKey   = errors.New("ipstack access key is invalid or inactive")
+	ErrIPStackQuotaReached = errors.New("ipstack usage limit reached")
+	ErrIPStackInvalidIP    = errors.New("ipstack rejected the IP address")
+	ErrIPStackRateLimited  = errors.New("ipstack rate limit reached")
+	ErrIPStackAPI          = errors.New("ipstack API error")
+)
+
+// IPStackError is returned when ipstack answers with {"success": false, "error": {...}}
+type IPStackError struct {
+	Code int    `json:"code"`
+	Type string `json:"type"`
+	Info string `json:"info"`
+}
+
+func (e *IPStackError) Error() string {
+	return fmt.Sprintf("ipstack error %v (%v): %v", e.Code, e.Type, e.Info)
+}
+
+func (e *IPStackError) Unwrap() error {
+	switch {
+	case e.Code == 101 || e.Code == 102 || e.Type == "invalid_access_key" || e.Type == "missing_access_key":
+		return ErrIPStackInvalidKey
+	case e.Code == 104 || e.Type == "usage_limit_reached":
+		return ErrIPStackQuotaReached
+	case e.Code == 106 || e.Type == "invalid_ip_address":
+		return ErrIPStackInvalidIP
+	case e.Code == 429 || e.Type == "rate_limit_reached":
+		return ErrIPStackRateLimited
+	default:
+		return ErrIPStackAPI
+	}
+}
+
+type ipStackErrorResponse struct {
+	Success *bool         `json:"success"`
+	Error   *IPStackError `json:"error"`
+}
+
+type IPStackProvider struct {
+	URL       string
+	AccessKey string
+	Client    *HTTPClient
+}
+
+func (p *IPStackProvider) Name() string {
+	return "ipstack"
+}
+
+func (p *IPStackProvider) GetIPInfo(ctx context.Context, ip net.IP) (*IPInfo, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL+ip.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	query := request.URL.Query()
+	query.Add("access_key", p.AccessKey)
+	request.URL.RawQuery = query.Encode()
+
+	responseBytes, err := p.Client.Do(ctx, request)
+	if err != nil {
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+			return nil, &IPStackError{Code: statusErr.StatusCode, Type: "rate_limit_reached", Info: statusErr.Status}
+		}
+		return nil, err
+	}
+
+	errResp := ipStackErrorResponse{}
+	err = json.Unmarshal(responseBytes, &errResp)
+	if err != nil {
+		return nil, err
+	}
+	if errResp.Success != nil && !*errResp.Success {
+		if errResp.Error == nil {
+			return nil, ErrIPStackAPI
+		}
+		return nil, errResp.Error
+	}
+
+	IPData := IPInfo{}
+	err = json.Unmarshal(responseBytes, &IPData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IPData, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIPStackProviderGetIPInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantErr  error
+		wantText string
+	}{
+		{
+			name:     "invalid access key",
+			status:   http.StatusOK,
+			body:     `{"success": false, "error": {"code": 101, "type": "invalid_access_key", "info": "You have not supplied a valid API Access Key."}}`,
+			wantErr:  ErrIPStackInvalidKey,
+			wantText: "IP checks are temporarily unavailable, admins are notified\nTry again later",
+		},
+		{
+			name:     "usage limit",
+			status:   http.StatusOK,
+			body:     `{"success": false, "error": {"code": 104, "type": "usage_limit_reached", "info": "Your monthly usage limit has been reached."}}`,
+			wantErr:  ErrIPStackQuotaReached,
+			wantText: "IP checks are temporarily unavailable, admins are notified\nTry again later",
+		},
+		{
+			name:     "invalid IP",
+			status:   http.StatusOK,
+			body:     `{"success": false, "error": {"code": 106, "type": "invalid_ip_address", "info": "The IP Address supplied is invalid."}}`,
+			wantErr:  ErrIPStackInvalidIP,
+			wantText: "This IP address can't be checked",
+		},
+		{
+			name:     "rate limit payload",
+			status:   http.StatusOK,
+			body:     `{"success": false, "error": {"code": 429, "type": "rate_limit_reached", "info": "Too many requests."}}`,
+			wantErr:  ErrIPStackRateLimited,
+			wantText: "Too many checks right now\nTry again in a minute",
+		},
+		{
+			name:     "rate limit status",
+			status:   http.StatusTooManyRequests,
+			body:     `Too Many Requests`,
+			wantErr:  ErrIPStackRateLimited,
+			wantText: "Too many checks right now\nTry again in a minute",
+		},
+		{
+			name:     "failure without error",
+			status:   http.StatusOK,
+			body:     `{"success": false}`,
+			wantErr:  ErrIPStackAPI,
+			wantText: "Can't get information about this IP address right now\nTry again later",
+		},
+		{
+			name:   "success",
+			status: http.StatusOK,
+			body:   `{"ip": "192.0.2.1", "type": "ipv4", "country_code": "NL", "country_name": "Netherlands", "city": "Amsterdam"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/192.0.2.1" || r.URL.Query().Get("access_key") != "key" {
+					t.Errorf("unexpected request %v", r.URL)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			provider := &IPStackProvider{URL: server.URL + "/", AccessKey: "key", Client: NewHTTPClient(time.Second, 0, 0)}
+			ipInfo, err := provider.GetIPInfo(context.Background(), net.ParseIP("192.0.2.1"))
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ipInfo.CountryCode != "NL" || ipInfo.City != "Amsterdam" {
+					t.Errorf("GetIPInfo() = %+v", ipInfo)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("GetIPInfo() error = %v, want %v", err, tt.wantErr)
+			}
+			if text := geoErrorText(err); text != tt.wantText {
+				t.Errorf("geoErrorText() = %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
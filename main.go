@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -20,6 +26,7 @@ type Env struct {
 		Insert(user *User) error
 		UpdateInfo(user *User, updateUserData *User) error
 		SetAdminStatus(tgID int, isAdmin bool) error
+		SetSendLocation(tgID int, sendLocation bool) error
 		HandlerGetUsers(w http.ResponseWriter, r *http.Request)
 		HandlerGetUser(w http.ResponseWriter, r *http.Request)
 	}
@@ -27,15 +34,47 @@ type Env struct {
 	ipChecks interface {
 		List() ([]IPCheck, error)
 		ListByTgID(tgID int, uniq bool) ([]IPCheck, error)
+		GetLatestByTgID(tgID int, ip string) (*IPCheck, error)
+		GetLatestByIP(ip string, since time.Time) (*IPCheck, error)
 		Insert(ipCheck *IPCheck) error
 		Delete(ipCheckID int) error
 		HandlerGetHistory(w http.ResponseWriter, r *http.Request)
+		HandlerGetHistoryDiff(w http.ResponseWriter, r *http.Request)
 		HandlerDeleteHistoryRecord(w http.ResponseWriter, r *http.Request)
 	}
 
+	watchedIPs interface {
+		ListByTgID(tgID int) ([]WatchedIP, error)
+		ListDue(checkedBefore time.Time) ([]WatchedIP, error)
+		Insert(watchedIP *WatchedIP) error
+		UpdateInfo(id int, ipInfo datatypes.JSON) error
+		Delete(tgID int, ip string) error
+	}
+
+	dialogs interface {
+		Get(chatID int64) (string, error)
+		Set(chatID int64, state string, expiresAt time.Time) error
+		Delete(chatID int64) error
+		DeleteExpired() error
+	}
+
 	errLogs interface{
 		Write(p []byte) (n int, err error)
 	}
+
+	geo      GeoProvider
+	resolver *net.Resolver
+
+	asn interface {
+		Lookup(ip net.IP) *ASNRecord
+	}
+
+	// webhook is nil in the long polling mode
+	webhook *WebhookHandler
+
+	enrich interface {
+		Run(ctx context.Context, ip net.IP) (*IPInfo, map[string]error)
+	}
 }
 
 func main() {
@@ -45,6 +84,10 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	// SIGINT and SIGTERM stop the API and the bot, the received bot updates are handled before the exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Connecting to DB
 	time.Sleep(5*time.Second)
 
@@ -56,7 +99,7 @@ func main() {
 	}
 
 	// DB migration
-	err = db.AutoMigrate(User{}, IPCheck{}, ErrLog{})
+	err = db.AutoMigrate(User{}, IPCheck{}, WatchedIP{}, DialogState{}, ErrLog{})
 	if err != nil {
 		log.Fatal("Error run db migration")
 	}
@@ -68,21 +111,130 @@ func main() {
 	}
 	db.Create(&User{TgID: initAdminID, IsAdmin: true})
 
+	// Geo provider
+	httpRetries, err := strconv.Atoi(os.Getenv("HTTP_RETRIES"))
+	if err != nil {
+		httpRetries = 2
+	}
+	httpClient := NewHTTPClient(getEnvDuration("HTTP_TIMEOUT", 10*time.Second), httpRetries,
+		getEnvDuration("HTTP_BACKOFF", 500*time.Millisecond))
+
+	breakerFailures, err := strconv.Atoi(os.Getenv("GEO_BREAKER_FAILURES"))
+	if err != nil {
+		breakerFailures = 3
+	}
+	breakerCooldown, err := time.ParseDuration(os.Getenv("GEO_BREAKER_COOLDOWN"))
+	if err != nil {
+		breakerCooldown = time.Minute
+	}
+	chain, err := newGeoProviderChain(os.Getenv("GEO_PROVIDER"), httpClient, breakerFailures, breakerCooldown)
+	if err != nil {
+		log.Fatal("Error initializing geo provider: ", err)
+	}
+	var geo GeoProvider = chain
+	if os.Getenv("GEO_CACHE_TTL") != "" {
+		ttl, err := time.ParseDuration(os.Getenv("GEO_CACHE_TTL"))
+		if err != nil {
+			log.Fatal("Error parsing GEO_CACHE_TTL value from .env file")
+		}
+		if ttl > 0 {
+			cache := NewCachedGeoProvider(geo, ttl)
+			if os.Getenv("GEO_CACHE_DB") == "true" {
+				cache.Store = &IPCheckModel{db}
+			}
+			geo = cache
+		}
+	}
+
 	// Env
 	env := &Env{
 		users: &UserModel{db},
 		ipChecks: &IPCheckModel{db},
+		watchedIPs: &WatchedIPModel{db},
+		dialogs: &DialogStateModel{db},
 		errLogs:  &ErrLogModel{db},
+		geo:      geo,
+		resolver: newResolver(os.Getenv("DNS_SERVER")),
+	}
+	if os.Getenv("ASN_DB_PATH") != "" {
+		asnDB, err := NewASNDatabase(os.Getenv("ASN_DB_PATH"))
+		if err != nil {
+			log.Fatal("Error loading ASN database: ", err)
+		}
+		env.asn = asnDB
+	}
+
+	// Enrichment pipeline, steps run in this order
+	pipeline := &EnrichmentPipeline{}
+	pipeline.Add(&ClassEnricher{}, time.Second)
+	pipeline.Add(&GeoEnricher{Provider: env.geo}, getEnvDuration("GEO_TIMEOUT", 20*time.Second))
+	if env.asn != nil {
+		pipeline.Add(&ASNEnricher{DB: env.asn}, time.Second)
+	}
+	if os.Getenv("BLOCKLIST_DIR") != "" {
+		blocklists, err := NewBlocklists(os.Getenv("BLOCKLIST_DIR"))
+		if err != nil {
+			log.Fatal("Error loading blocklists: ", err)
+		}
+		go blocklists.Watch(ctx, getEnvDuration("BLOCKLIST_RELOAD", 10*time.Minute))
+		pipeline.Add(&BlocklistEnricher{Blocklists: blocklists}, time.Second)
+	}
+	if os.Getenv("CLOUD_RANGES_DIR") != "" {
+		cloudRanges, err := NewCloudRanges(os.Getenv("CLOUD_RANGES_DIR"))
+		if err != nil {
+			log.Fatal("Error loading cloud ranges: ", err)
+		}
+		go cloudRanges.Watch(ctx, getEnvDuration("CLOUD_RANGES_RELOAD", time.Hour))
+		pipeline.Add(&CloudEnricher{Ranges: cloudRanges}, time.Second)
 	}
+	if os.Getenv("DNSBL_ZONES") != "" {
+		zones := strings.Split(os.Getenv("DNSBL_ZONES"), ",")
+		for i := range zones {
+			zones[i] = strings.TrimSpace(zones[i])
+		}
+		checker := NewDNSBLChecker(env.resolver, zones, getEnvDuration("DNSBL_CACHE_TTL", time.Hour))
+		pipeline.Add(&DNSBLEnricher{Checker: checker}, getEnvDuration("DNSBL_TIMEOUT", 5*time.Second))
+	}
+	if os.Getenv("RDAP_URL") != "" {
+		rdap := &RDAPClient{BaseURL: os.Getenv("RDAP_URL"), Client: httpClient}
+		pipeline.Add(&RDAPEnricher{Client: rdap}, getEnvDuration("RDAP_TIMEOUT", 15*time.Second))
+	}
+	pipeline.Add(&RDNSEnricher{Resolver: env.resolver}, getEnvDuration("RDNS_TIMEOUT", 3*time.Second))
+	env.enrich = pipeline
 
 	// Setup logging
 	log.SetLevel(log.ErrorLevel)
 	log.SetFormatter(&log.TextFormatter{})
 	log.SetOutput(env.errLogs)
 
+	// Bot updates come either by long polling or to the webhook of the API server
+	switch os.Getenv("BOT_MODE") {
+	case "", "polling":
+	case "webhook":
+		env.webhook, err = NewWebhookHandler(os.Getenv("WEBHOOK_SECRET"))
+		if err != nil {
+			log.Fatal("Error parsing WEBHOOK_SECRET value from .env file: ", err)
+		}
+	default:
+		log.Fatal("Unknown BOT_MODE value in .env file, use polling or webhook")
+	}
+
 	// tg-bot up
-	go tgBot(env)
+	botDone := make(chan struct{})
+	go func() {
+		tgBot(ctx, env)
+		close(botDone)
+	}()
 
 	// web-server up
-	API(env)
-}
\ No newline at end of file
+	API(ctx, env)
+	<-botDone
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
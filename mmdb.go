@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+	"strings"
+	"sync"
+)
+
+type mmdbCityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Continent struct {
+		Code  string            `maxminddb:"code"`
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"continent"`
+	Country struct {
+		GeoNameID         int               `maxminddb:"geoname_id"`
+		IsInEuropeanUnion bool              `maxminddb:"is_in_european_union"`
+		ISOCode           string            `maxminddb:"iso_code"`
+		Names             map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	Postal struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+type MMDBProvider struct {
+	Path string
+
+	mu     sync.RWMutex
+	reader *maxminddb.Reader
+}
+
+func NewMMDBProvider(path string) (*MMDBProvider, error) {
+	p := &MMDBProvider{Path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *MMDBProvider) Name() string {
+	return "mmdb"
+}
+
+// Reload reopens the database file, so an updated file is picked up without restart
+func (p *MMDBProvider) Reload() error {
+	reader, err := maxminddb.Open(p.Path)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	old := p.reader
+	p.reader = reader
+	p.mu.Unlock()
+
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+func (p *MMDBProvider) GetIPInfo(ctx context.Context, ip net.IP) (*IPInfo, error) {
+	record := mmdbCityRecord{}
+
+	p.mu.RLock()
+	offset, err := p.reader.LookupOffset(ip)
+	if err == nil && offset != maxminddb.NotFound {
+		err = p.reader.Decode(offset, &record)
+	}
+	p.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	// Lookup leaves the record empty for addresses missing in the database
+	if offset == maxminddb.NotFound {
+		return nil, ErrGeoNotFound
+	}
+
+	ipInfo := IPInfo{
+		IP:            ip.String(),
+		Type:          ipType(ip),
+		ContinentCode: record.Continent.Code,
+		ContinentName: record.Continent.Names["en"],
+		CountryCode:   record.Country.ISOCode,
+		CountryName:   record.Country.Names["en"],
+		City:          record.City.Names["en"],
+		Zip:           record.Postal.Code,
+		Latitude:      record.Location.Latitude,
+		Longitude:     record.Location.Longitude,
+	}
+	if len(record.Subdivisions) > 0 {
+		ipInfo.RegionCode = record.Subdivisions[0].ISOCode
+		ipInfo.RegionName = record.Subdivisions[0].Names["en"]
+	}
+	ipInfo.Location.GeonameID = record.Country.GeoNameID
+	ipInfo.Location.IsEu = record.Country.IsInEuropeanUnion
+	ipInfo.Location.CountryFlagEmoji = countryFlagEmoji(record.Country.ISOCode)
+
+	return &ipInfo, nil
+}
+
+func countryFlagEmoji(countryCode string) string {
+	if len(countryCode) != 2 {
+		return ""
+	}
+	flag := ""
+	for _, r := range strings.ToUpper(countryCode) {
+		if r < 'A' || r > 'Z' {
+			return ""
+		}
+		flag += string(rune(0x1F1E6 + r - 'A'))
+	}
+	return flag
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type OwnerInfo struct {
+	Name       string `json:"name,omitempty"`
+	Handle     string `json:"handle,omitempty"`
+	CIDR       string `json:"cidr,omitempty"`
+	Org        string `json:"org,omitempty"`
+	AbuseEmail string `json:"abuse_email,omitempty"`
+	Registry   string `json:"registry,omitempty"`
+}
+
+type rdapEntity struct {
+	Roles      []string          `json:"roles"`
+	VCardArray []json.RawMessage `json:"vcardArray"`
+	Entities   []rdapEntity      `json:"entities"`
+}
+
+type rdapNetwork struct {
+	Handle       string `json:"handle"`
+	Name         string `json:"name"`
+	StartAddress string `json:"startAddress"`
+	EndAddress   string `json:"endAddress"`
+	Port43       string `json:"port43"`
+	CIDRs        []struct {
+		V4Prefix string `json:"v4prefix"`
+		V6Prefix string `json:"v6prefix"`
+		Length   int    `json:"length"`
+	} `json:"cidr0_cidrs"`
+	Entities []rdapEntity `json:"entities"`
+}
+
+// RDAPClient looks up IP network registrations, BaseURL is e.g. https://rdap.org/
+type RDAPClient struct {
+	BaseURL string
+	Client  *HTTPClient
+}
+
+func (c *RDAPClient) Lookup(ctx context.Context, ip net.IP) (*OwnerInfo, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.BaseURL, "/")+"/ip/"+ip.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/rdap+json")
+
+	body, err := c.Client.Do(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	network := rdapNetwork{}
+	if err := json.Unmarshal(body, &network); err != nil {
+		return nil, err
+	}
+
+	owner := &OwnerInfo{
+		Name:     network.Name,
+		Handle:   network.Handle,
+		Registry: rdapRegistry(network.Port43),
+	}
+	cidrs := make([]string, 0, len(network.CIDRs))
+	for _, cidr := range network.CIDRs {
+		prefix := cidr.V4Prefix
+		if prefix == "" {
+			prefix = cidr.V6Prefix
+		}
+		cidrs = append(cidrs, fmt.Sprintf("%v/%v", prefix, cidr.Length))
+	}
+	owner.CIDR = strings.Join(cidrs, ", ")
+	if owner.CIDR == "" && network.StartAddress != "" {
+		owner.CIDR = network.StartAddress + " - " + network.EndAddress
+	}
+
+	for _, entity := range network.Entities {
+		if hasRole(entity, "registrant") && owner.Org == "" {
+			owner.Org = vcardField(entity.VCardArray, "fn")
+		}
+	}
+	owner.AbuseEmail = findAbuseEmail(network.Entities)
+	return owner, nil
+}
+
+func hasRole(entity rdapEntity, role string) bool {
+	for _, r := range entity.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// findAbuseEmail searches the abuse contact, it is often nested in the registrant entity
+func findAbuseEmail(entities []rdapEntity) string {
+	for _, entity := range entities {
+		if hasRole(entity, "abuse") {
+			if email := vcardField(entity.VCardArray, "email"); email != "" {
+				return email
+			}
+		}
+		if email := findAbuseEmail(entity.Entities); email != "" {
+			return email
+		}
+	}
+	return ""
+}
+
+// vcardField reads a property from a jCard: ["vcard", [[name, params, type, value], ...]]
+func vcardField(vcard []json.RawMessage, field string) string {
+	if len(vcard) < 2 {
+		return ""
+	}
+	properties := make([][]json.RawMessage, 0)
+	if err := json.Unmarshal(vcard[1], &properties); err != nil {
+		return ""
+	}
+	for _, property := range properties {
+		if len(property) < 4 {
+			continue
+		}
+		name, value := "", ""
+		if json.Unmarshal(property[0], &name) == nil && name == field && json.Unmarshal(property[3], &value) == nil {
+			return value
+		}
+	}
+	return ""
+}
+
+func rdapRegistry(port43 string) string {
+	for _, registry := range []string{"arin", "ripe", "apnic", "lacnic", "afrinic"} {
+		if strings.Contains(port43, registry) {
+			return strings.ToUpper(registry)
+		}
+	}
+	return port43
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const rdapTestResponse = `{
+  "objectClassName": "ip network",
+  "handle": "NET-192-0-2-0-1",
+  "name": "TEST-NET-1",
+  "startAddress": "192.0.2.0",
+  "endAddress": "192.0.2.255",
+  "port43": "whois.arin.net",
+  "cidr0_cidrs": [{"v4prefix": "192.0.2.0", "length": 24}],
+  "entities": [
+    {
+      "roles": ["registrant"],
+      "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Org"]]],
+      "entities": [
+        {
+          "roles": ["abuse"],
+          "vcardArray": ["vcard", [["fn", {}, "text", "Abuse"], ["email", {}, "text", "abuse@example.org"]]]
+        }
+      ]
+    }
+  ]
+}`
+
+func TestRDAPClientLookup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ip/192.0.2.1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		if _, err := w.Write([]byte(rdapTestResponse)); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	client := &RDAPClient{BaseURL: server.URL + "/", Client: NewHTTPClient(5*time.Second, 0, 0)}
+	owner, err := client.Lookup(context.Background(), net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := OwnerInfo{
+		Name:       "TEST-NET-1",
+		Handle:     "NET-192-0-2-0-1",
+		CIDR:       "192.0.2.0/24",
+		Org:        "Example Org",
+		AbuseEmail: "abuse@example.org",
+		Registry:   "ARIN",
+	}
+	if *owner != want {
+		t.Errorf("Lookup() = %+v, want %+v", *owner, want)
+	}
+
+	if _, err := client.Lookup(context.Background(), net.ParseIP("198.51.100.1")); err == nil {
+		t.Error("Lookup() of an unknown network should fail")
+	}
+}
+
+func TestRDAPClientLookupAddressRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"handle": "2001:DB8::/32", "startAddress": "2001:db8::", "endAddress": "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", "port43": "whois.ripe.net"}`))
+	}))
+	defer server.Close()
+
+	client := &RDAPClient{BaseURL: server.URL, Client: NewHTTPClient(5*time.Second, 0, 0)}
+	owner, err := client.Lookup(context.Background(), net.ParseIP("2001:db8::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.CIDR != "2001:db8:: - 2001:db8:ffff:ffff:ffff:ffff:ffff:ffff" || owner.Registry != "RIPE" {
+		t.Errorf("Lookup() = %+v", *owner)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+)
+
+type SubnetInfo struct {
+	CIDR      string `json:"cidr"`
+	Network   string `json:"network"`
+	Broadcast string `json:"broadcast,omitempty"`
+	Mask      string `json:"mask"`
+	Wildcard  string `json:"wildcard"`
+	Prefix    int    `json:"prefix"`
+	Addresses string `json:"addresses"`
+	Hosts     string `json:"hosts"`
+	FirstHost string `json:"first_host"`
+	LastHost  string `json:"last_host"`
+}
+
+func getSubnetInfo(network *net.IPNet) *SubnetInfo {
+	ones, bits := network.Mask.Size()
+	ip := network.IP.Mask(network.Mask)
+
+	wildcard := make(net.IPMask, len(network.Mask))
+	last := make(net.IP, len(ip))
+	for i := range ip {
+		wildcard[i] = ^network.Mask[i]
+		last[i] = ip[i] | wildcard[i]
+	}
+
+	addresses := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	hosts := new(big.Int).Set(addresses)
+	first, lastHost := ip, last
+
+	info := &SubnetInfo{
+		CIDR:    network.String(),
+		Network: ip.String(),
+		Prefix:  ones,
+	}
+	if bits == 32 {
+		info.Mask = net.IP(network.Mask).String()
+		info.Wildcard = net.IP(wildcard).String()
+		info.Broadcast = last.String()
+		// Network and broadcast addresses aren't usable, except /31 (RFC 3021) and /32
+		if ones < 31 {
+			hosts.Sub(hosts, big.NewInt(2))
+			first, lastHost = addIP(ip, 1), addIP(last, -1)
+		}
+	} else {
+		// net.IP.String would print masks like ::ffff:ffff as IPv4-mapped addresses
+		info.Mask = ipv6Groups(network.Mask)
+		info.Wildcard = ipv6Groups(wildcard)
+	}
+	info.Addresses = addresses.String()
+	info.Hosts = hosts.String()
+	info.FirstHost = first.String()
+	info.LastHost = lastHost.String()
+	return info
+}
+
+func ipv6Groups(b []byte) string {
+	groups := make([]string, 0, 8)
+	for i := 0; i+1 < len(b); i += 2 {
+		groups = append(groups, fmt.Sprintf("%x", uint16(b[i])<<8|uint16(b[i+1])))
+	}
+	return strings.Join(groups, ":")
+}
+
+func addIP(ip net.IP, delta int64) net.IP {
+	n := new(big.Int).SetBytes(ip)
+	n.Add(n, big.NewInt(delta))
+	result := make(net.IP, len(ip))
+	n.FillBytes(result)
+	return result
+}
+
+func (s *SubnetInfo) MessageString() string {
+	message := "<code>Subnet:</code> " + s.CIDR
+	message += "\n<code>Network:</code> " + s.Network
+	if s.Broadcast != "" {
+		message += "\n<code>Broadcast:</code> " + s.Broadcast
+	}
+	message += "\n<code>Mask:</code> " + s.Mask
+	message += "\n<code>Wildcard:</code> " + s.Wildcard
+	message += "\n<code>Addresses:</code> " + s.Addresses
+	message += "\n<code>Usable hosts:</code> " + s.Hosts
+	message += "\n<code>First host:</code> " + s.FirstHost
+	message += "\n<code>Last host:</code> " + s.LastHost
+	return message
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGetSubnetInfo(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want SubnetInfo
+	}{
+		{
+			cidr: "192.168.1.0/24",
+			want: SubnetInfo{
+				CIDR: "192.168.1.0/24", Network: "192.168.1.0", Broadcast: "192.168.1.255",
+				Mask: "255.255.255.0", Wildcard: "0.0.0.255", Prefix: 24,
+				Addresses: "256", Hosts: "254", FirstHost: "192.168.1.1", LastHost: "192.168.1.254",
+			},
+		},
+		{
+			cidr: "10.0.0.0/31",
+			want: SubnetInfo{
+				CIDR: "10.0.0.0/31", Network: "10.0.0.0", Broadcast: "10.0.0.1",
+				Mask: "255.255.255.254", Wildcard: "0.0.0.1", Prefix: 31,
+				Addresses: "2", Hosts: "2", FirstHost: "10.0.0.0", LastHost: "10.0.0.1",
+			},
+		},
+		{
+			cidr: "8.8.8.8/32",
+			want: SubnetInfo{
+				CIDR: "8.8.8.8/32", Network: "8.8.8.8", Broadcast: "8.8.8.8",
+				Mask: "255.255.255.255", Wildcard: "0.0.0.0", Prefix: 32,
+				Addresses: "1", Hosts: "1", FirstHost: "8.8.8.8", LastHost: "8.8.8.8",
+			},
+		},
+		{
+			cidr: "2001:db8::/64",
+			want: SubnetInfo{
+				CIDR: "2001:db8::/64", Network: "2001:db8::",
+				Mask: "ffff:ffff:ffff:ffff:0:0:0:0", Wildcard: "0:0:0:0:ffff:ffff:ffff:ffff", Prefix: 64,
+				Addresses: "18446744073709551616", Hosts: "18446744073709551616",
+				FirstHost: "2001:db8::", LastHost: "2001:db8::ffff:ffff:ffff:ffff",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		_, network, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := getSubnetInfo(network); *got != tt.want {
+			t.Errorf("getSubnetInfo(%v) = %+v, want %+v", tt.cidr, *got, tt.want)
+		}
+	}
+}
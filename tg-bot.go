@@ -1,19 +1,43 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"html"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
+const checkTimeout = 2 * time.Minute
+
+// inlineQueryTimeout is shorter, as the client waits for inline results while the user types
+const inlineQueryTimeout = 5 * time.Second
+
+// sendAttempts limits resending of a message the bot API fails to accept
+const sendAttempts = 3
+
+// Dialog states, the next text message of the chat is handled according to its state
+const (
+	dialogStateCheckIP     = "check_ip"
+	dialogStateWatchIP     = "watch_ip"
+	dialogStateUnwatchIP   = "unwatch_ip"
+	dialogStateBroadcast   = "broadcast"
+	dialogStateUserChecks  = "user_checks"
+	dialogStateAddAdmin    = "add_admin"
+	dialogStateRemoveAdmin = "remove_admin"
+)
+
+const dialogCancelHint = "\nSend /cancel to abort"
+
 func getUserKeyboard() tgbotapi.ReplyKeyboardMarkup {
 	return tgbotapi.NewReplyKeyboard(
 		tgbotapi.NewKeyboardButtonRow(
@@ -23,6 +47,13 @@ func getUserKeyboard() tgbotapi.ReplyKeyboardMarkup {
 			tgbotapi.NewKeyboardButton("Get list of checked IPs"),
 			tgbotapi.NewKeyboardButton("Get list of checked IPs results"),
 		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("Watch IP"),
+			tgbotapi.NewKeyboardButton("My watchlist"),
+		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("Toggle location pin"),
+		),
 	)
 }
 
@@ -39,7 +70,7 @@ func getAdminKeyboard() tgbotapi.ReplyKeyboardMarkup {
 	)
 }
 
-func tgBot(env *Env) {
+func tgBot(ctx context.Context, env *Env) {
 	bot, err := tgbotapi.NewBotAPI(os.Getenv("TG_BOT_TOKEN"))
 	if err != nil {
 		log.Error(err)
@@ -47,277 +78,746 @@ func tgBot(env *Env) {
 	}
 
 	sendSafe := func(c tgbotapi.Chattable) {
-		for _, err := bot.Send(c); err != nil; {
+		for attempt := 1; ; attempt++ {
+			_, err := bot.Send(c)
+			if err == nil {
+				return
+			}
 			log.Error(err)
+			if attempt == sendAttempts {
+				return
+			}
 			time.Sleep(2 * time.Second)
 		}
 	}
 
-	fmt.Printf("Authorized on account %s", bot.Self.UserName)
+	// Admins are alerted about geo provider problems at most once per hour
+	var lastAdminAlert time.Time
+	var lastAdminAlertMu sync.Mutex
+	notifyAdmins := func(text string) {
+		lastAdminAlertMu.Lock()
+		if time.Since(lastAdminAlert) < time.Hour {
+			lastAdminAlertMu.Unlock()
+			return
+		}
+		lastAdminAlert = time.Now()
+		lastAdminAlertMu.Unlock()
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+		users, err := env.users.List()
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		for _, user := range users {
+			if user.IsAdmin {
+				sendSafe(tgbotapi.NewMessage(int64(user.TgID), text))
+			}
+		}
+	}
 
-	updates, err := bot.GetUpdatesChan(u)
-	if err != nil {
-		log.Error(err)
-		return
+	// lookupIP runs the address through the enrichment pipeline
+	lookupIP := func(ctx context.Context, ipAddr net.IP) *IPInfo {
+		ipInfo, errs := env.enrich.Run(ctx, ipAddr)
+		for name, err := range errs {
+			log.Error(name, ": ", err)
+		}
+		if err := errs["geo"]; errors.Is(err, ErrIPStackInvalidKey) || errors.Is(err, ErrIPStackQuotaReached) {
+			notifyAdmins("Geo lookup problem: " + err.Error())
+		}
+		return ipInfo
+	}
+
+	// checkIP looks up the address and stores the check, the diff against the user's previous check
+	// of the address is nil if there is none
+	checkIP := func(ctx context.Context, user *User, ipAddr net.IP, query string) (*IPInfo, *IPCheckDiff) {
+		ipInfo := lookupIP(ctx, ipAddr)
+		prevCheck, err := env.ipChecks.GetLatestByTgID(user.TgID, ipAddr.String())
+		if err != nil {
+			log.Error(err)
+		}
+
+		ipCheck := &IPCheck{
+			IP:       ipAddr.String(),
+			Kind:     IPCheckKindIP,
+			Query:    query,
+			IPInfo:   ipInfo.JSONBytes(),
+			Provider: ipInfo.Provider,
+			Class:    ipInfo.Class,
+			UserTgID: user.TgID,
+		}
+		if err := env.ipChecks.Insert(ipCheck); err != nil {
+			log.Error(err)
+		}
+		if prevCheck == nil {
+			return ipInfo, nil
+		}
+
+		diff, err := diffIPChecks(prevCheck, ipCheck)
+		if err != nil {
+			log.Error(err)
+		}
+		return ipInfo, diff
 	}
 
-	// Optional: wait for updates and clear them if you don't want to handle
-	// a large backlog of old messages
-	time.Sleep(time.Millisecond * 500)
-	updates.Clear()
+	// ipCheckMessage renders the check result followed by the changes since the previous check
+	ipCheckMessage := func(ipInfo *IPInfo, diff *IPCheckDiff) string {
+		if diff == nil {
+			return ipInfo.MessageString()
+		}
+		return ipInfo.MessageString() + diff.MessageString()
+	}
 
-UpdateLoop:
-	for update := range updates {
-		// Check is user in DB
-		user, err := env.users.Get(update.Message.From.ID)
-		switch {
-		case errors.Is(err, ErrUserNotFound):
-			// If not exist -> create
-			user = getNewUser(update.Message.From)
-			if err := env.users.Insert(user); err != nil {
-				log.Error(err)
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something goes wrong")
-				sendSafe(msg)
-				continue UpdateLoop
+	// ipInfoVenue returns a location pin of the approximate address location, if it is known
+	ipInfoVenue := func(ipInfo *IPInfo, chatID int64) (tgbotapi.VenueConfig, bool) {
+		if ipInfo.Latitude == 0 && ipInfo.Longitude == 0 {
+			return tgbotapi.VenueConfig{}, false
+		}
+
+		places := make([]string, 0, 2)
+		for _, place := range []string{ipInfo.City, ipInfo.CountryName} {
+			if place != "" {
+				places = append(places, place)
 			}
+		}
+		title := strings.Join(places, ", ")
+		if title == "" {
+			title = ipInfo.IP
+		}
+		address := ipInfo.IP
+		if ipInfo.RegionName != "" {
+			address = ipInfo.RegionName + ", " + ipInfo.IP
+		}
+		return tgbotapi.NewVenue(chatID, title, address, ipInfo.Latitude, ipInfo.Longitude), true
+	}
 
-		case err != nil:
+	// checkSubnet calculates the subnet, optionally geolocates its network address and stores the check
+	subnetGeo := os.Getenv("SUBNET_GEO") == "true"
+	checkSubnet := func(ctx context.Context, user *User, network *net.IPNet) *IPInfo {
+		ipInfo := &IPInfo{IP: network.IP.String(), Type: ipType(network.IP)}
+		if subnetGeo {
+			ipInfo = lookupIP(ctx, network.IP)
+		} else if err := (&ClassEnricher{}).Enrich(ctx, network.IP, ipInfo); err != nil {
+			log.Error(err)
+		}
+		ipInfo.Subnet = getSubnetInfo(network)
+
+		err := env.ipChecks.Insert(&IPCheck{
+			IP:       network.String(),
+			Kind:     IPCheckKindSubnet,
+			IPInfo:   ipInfo.JSONBytes(),
+			Provider: ipInfo.Provider,
+			Class:    ipInfo.Class,
+			UserTgID: user.TgID,
+		})
+		if err != nil {
 			log.Error(err)
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something goes wrong")
-			sendSafe(msg)
-			continue UpdateLoop
+		}
+		return ipInfo
+	}
+
+	// checkQuery checks a parsed query of any kind and returns the reply text with the check results
+	checkQuery := func(ctx context.Context, user *User, query *CheckQuery) (string, []*IPInfo, error) {
+		switch {
+		case query.IP != nil:
+			ipInfo, diff := checkIP(ctx, user, query.IP, "")
+			return ipCheckMessage(ipInfo, diff), []*IPInfo{ipInfo}, nil
+
+		case query.Network != nil:
+			ipInfo := checkSubnet(ctx, user, query.Network)
+			return ipInfo.MessageString(), []*IPInfo{ipInfo}, nil
 
 		default:
-			// If exist -> update
-			if err := env.users.UpdateInfo(user, getNewUser(update.Message.From)); err != nil {
-				log.Error(err)
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something goes wrong")
-				sendSafe(msg)
-				continue UpdateLoop
+			ipAddrs, err := resolveHost(ctx, env.resolver, query.Host)
+			if err != nil {
+				if !errors.Is(err, ErrHostNotFound) {
+					log.Error(err)
+				}
+				return "", nil, err
 			}
+			text := fmt.Sprintf("<code>%v</code> resolves to %v address(es)", query.Host, len(ipAddrs))
+			ipInfos := make([]*IPInfo, 0, len(ipAddrs))
+			for _, ipAddr := range ipAddrs {
+				ipInfo, diff := checkIP(ctx, user, ipAddr, query.Host)
+				text += "\n\n" + ipCheckMessage(ipInfo, diff)
+				ipInfos = append(ipInfos, ipInfo)
+			}
+			return text, ipInfos, nil
 		}
+	}
 
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
-		switch user.IsAdmin {
-		case true:
-			tgbotapi.NewRemoveKeyboard(true)
-			msg.ReplyMarkup = getAdminKeyboard()
-			switch {
-			case update.Message != nil && update.Message.IsCommand():
-				switch update.Message.Command() {
+	bulkWorkers, err := strconv.Atoi(os.Getenv("BULK_WORKERS"))
+	if err != nil {
+		bulkWorkers = defaultBulkWorkers
+	}
 
-				case "start":
-					msg.Text = "Hi. Use the keyboard for actions."
+	// checkBulk checks every entry concurrently and returns paginated reply texts
+	checkBulk := func(ctx context.Context, user *User, entries []string) []string {
+		results := make([]string, len(entries))
+		errs := make([]error, len(entries))
+		runPool(len(entries), bulkWorkers, func(i int) {
+			query, err := parseQuery(entries[i])
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i], _, errs[i] = checkQuery(ctx, user, query)
+		})
 
-				default:
-					msg.Text = "I don't know that command"
-				}
-			case update.Message != nil && update.Message.ReplyToMessage == nil:
-				switch update.Message.Text {
-				case "Send broadcast message":
-					msg.ParseMode = "html"
-					msg.Text = "Send broadcast message\n" +
-						"Reply to this message with broadcast message text"
-
-				case "Get list of user's checked IPs":
-					msg.ParseMode = "html"
-					msg.Text = "Get list of user's checked IPs\n" +
-						"Reply to this message with user Telegram ID"
-
-				case "Add new admin":
-					msg.ParseMode = "html"
-					msg.Text = "Add new admin\n" +
-						"Reply to this message with new admin Telegram ID\n" +
-						"NB: new admin should have a dialogue with me!"
-
-				case "Remove admin":
-					msg.ParseMode = "html"
-					msg.Text = "Remove admin\n" +
-						"Reply to this message with deprecated admin Telegram ID"
+		parts := make([]string, 0, len(entries)+1)
+		invalid := make([]string, 0)
+		for i := range entries {
+			if errs[i] != nil {
+				invalid = append(invalid, fmt.Sprintf("<code>%v</code>: %v", html.EscapeString(entries[i]), errs[i]))
+				continue
+			}
+			parts = append(parts, results[i])
+		}
+
+		summary := fmt.Sprintf("Checked %v of %v entries", len(entries)-len(invalid), len(entries))
+		if len(invalid) > 0 {
+			summary += "\n\nInvalid entries:\n" + strings.Join(invalid, "\n")
+		}
+		return paginate(append(parts, summary), maxMessageLength)
+	}
 
+	// replyCheckIP handles an answer to the "Check IP" prompt. Bulk results and single check results
+	// followed by location pins are sent right away
+	replyCheckIP := func(c *BotContext) error {
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		defer cancel()
+
+		c.Reply.ParseMode = "html"
+		entries := splitBulkQuery(c.Text)
+		if len(entries) > maxBulkEntries {
+			c.RetryState()
+			c.Reply.Text = fmt.Sprintf("Too many entries, send at most %v at once\n", maxBulkEntries) +
+				"Try again" + dialogCancelHint
+			return nil
+		}
+		if len(entries) > 1 {
+			for i, page := range checkBulk(ctx, c.User, entries) {
+				pageMsg := tgbotapi.NewMessage(c.ChatID, page)
+				pageMsg.ParseMode = "html"
+				if i == 0 {
+					pageMsg.ReplyToMessageID = c.Reply.ReplyToMessageID
 				}
-			case update.Message != nil && update.Message.ReplyToMessage != nil && update.Message.ReplyToMessage.From.ID == bot.Self.ID:
-				switch strings.FieldsFunc(update.Message.ReplyToMessage.Text, func(r rune) bool { return r == '\n' })[0] {
-				case "Send broadcast message":
-					recipients, err := env.users.List()
-					if err != nil {
-						log.Error(err)
-					}
-					for _, recipient := range recipients {
-						broadcastMsg := tgbotapi.NewMessage(int64(recipient.TgID), "")
-						broadcastMsg.ParseMode = "html"
-						broadcastMsg.Text = update.Message.Text
-						sendSafe(broadcastMsg)
-					}
-
-				case "Get list of user's checked IPs":
-					msg.ParseMode = "html"
-					userTgID, err := strconv.Atoi(update.Message.Text)
-					if err != nil {
-						errMsg := tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("%v is invalid Telegram ID value\nShould be unsigned integer", update.Message.Text))
-						sendSafe(errMsg)
-						continue UpdateLoop
-					}
-					msg.Text = "Checked IPs:"
-					ipChecks, err := env.ipChecks.ListByTgID(userTgID, true)
-					switch {
-					case errors.Is(err, ErrUserNotFound):
-						errMsg := tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("User with Telegram ID %v not found", update.Message.Text))
-						sendSafe(errMsg)
-						continue UpdateLoop
-					case err != nil:
-						log.Error(err)
-						errMsg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something goes wrong\nTry again later")
-						sendSafe(errMsg)
-						continue UpdateLoop
-					}
-					for _, ipCheck := range ipChecks {
-						msg.Text += "\n" + ipCheck.IP
-					}
-
-				case "Add new admin":
-					msg.ParseMode = "html"
-					userTgID, err := strconv.Atoi(update.Message.Text)
-					if err != nil {
-						errMsg := tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("%v is invalid Telegram ID value\nShould be unsigned integer", update.Message.Text))
-						sendSafe(errMsg)
-						continue UpdateLoop
-					}
-
-					err = env.users.SetAdminStatus(userTgID, true)
-					switch {
-					case errors.Is(err, ErrUserNotFound):
-						errMsg := tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("User with Telegram ID %v not found", update.Message.Text))
-						sendSafe(errMsg)
-						continue UpdateLoop
-					case err != nil:
-						log.Error(err)
-						errMsg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something goes wrong\nTry again later")
-						sendSafe(errMsg)
-						continue UpdateLoop
-					}
-					msg.Text = "Success"
-
-				case "Remove admin":
-					msg.ParseMode = "html"
-					userTgID, err := strconv.Atoi(update.Message.Text)
-					if err != nil {
-						errMsg := tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("%v is invalid Telegram ID value\nShould be unsigned integer", update.Message.Text))
-						sendSafe(errMsg)
-						continue UpdateLoop
-					}
-
-					err = env.users.SetAdminStatus(userTgID, false)
-					switch {
-					case errors.Is(err, ErrUserNotFound):
-						errMsg := tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("User with Telegram ID %v not found", update.Message.Text))
-						sendSafe(errMsg)
-						continue UpdateLoop
-					case err != nil:
-						log.Error(err)
-						errMsg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something goes wrong\nTry again later")
-						sendSafe(errMsg)
-						continue UpdateLoop
-					}
-					msg.Text = "Success"
+				sendSafe(pageMsg)
+			}
+			return nil
+		}
+
+		query, err := parseQuery(c.Text)
+		if err != nil {
+			c.RetryState()
+			c.Reply.Text = fmt.Sprintf("<code>%v</code> is not a valid IP address, subnet, hostname or URL!\n", html.EscapeString(c.Text)) +
+				"Try again" + dialogCancelHint
+			return nil
+		}
+		text, ipInfos, err := checkQuery(ctx, c.User, query)
+		if err != nil {
+			c.RetryState()
+			c.Reply.Text = fmt.Sprintf("Can't resolve <code>%v</code>: %v\n", query.Host, err) +
+				"Try again" + dialogCancelHint
+			return nil
+		}
+
+		// Pins must follow the check result, so the result is sent right here. A hostname
+		// with many addresses may not fit one message
+		for i, page := range paginate([]string{text}, maxMessageLength) {
+			pageMsg := tgbotapi.NewMessage(c.ChatID, page)
+			pageMsg.ParseMode = "html"
+			if i == 0 {
+				pageMsg.ReplyToMessageID = c.Reply.ReplyToMessageID
+			}
+			sendSafe(pageMsg)
+		}
+
+		if c.User.SendLocation {
+			for _, ipInfo := range ipInfos {
+				if venue, ok := ipInfoVenue(ipInfo, c.ChatID); ok {
+					sendSafe(venue)
 				}
 			}
+		}
+		return nil
+	}
+
+	// replyWatchIP handles an answer to the "Watch IP" prompt, the first check is stored as the baseline
+	replyWatchIP := func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		ipAddr := net.ParseIP(strings.TrimSpace(c.Text))
+		if ipAddr == nil {
+			c.RetryState()
+			c.Reply.Text = fmt.Sprintf("<code>%v</code> is not a valid IP address!\n", html.EscapeString(c.Text)) +
+				"Try again" + dialogCancelHint
+			return nil
+		}
+
+		watchedIPs, err := env.watchedIPs.ListByTgID(c.User.TgID)
+		if err != nil {
+			return err
+		}
+		if len(watchedIPs) >= maxWatchedIPs {
+			c.Reply.Text = fmt.Sprintf("You can watch at most %v IPs\nRemove some of them from the watchlist first", maxWatchedIPs)
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		defer cancel()
+		ipInfo := lookupIP(ctx, ipAddr)
+		err = env.watchedIPs.Insert(&WatchedIP{
+			IP:        ipAddr.String(),
+			IPInfo:    ipInfo.JSONBytes(),
+			UserTgID:  c.User.TgID,
+			CheckedAt: time.Now(),
+		})
+		switch {
+		case errors.Is(err, ErrWatchedIPExists):
+			c.Reply.Text = fmt.Sprintf("<code>%v</code> is already in your watchlist", ipAddr)
+			return nil
+		case err != nil:
+			return err
+		}
+		c.Reply.Text = fmt.Sprintf("<code>%v</code> is added to your watchlist\nYou will be notified when its info changes\n\n", ipAddr) +
+			ipInfo.MessageString()
+		return nil
+	}
+
+	replyUnwatchIP := func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		ipAddr := net.ParseIP(strings.TrimSpace(c.Text))
+		if ipAddr == nil {
+			c.RetryState()
+			c.Reply.Text = fmt.Sprintf("<code>%v</code> is not a valid IP address!\n", html.EscapeString(c.Text)) +
+				"Try again" + dialogCancelHint
+			return nil
+		}
+		err := env.watchedIPs.Delete(c.User.TgID, ipAddr.String())
+		switch {
+		case errors.Is(err, ErrWatchedIPNotFound):
+			c.Reply.Text = fmt.Sprintf("<code>%v</code> is not in your watchlist", ipAddr)
+		case err != nil:
+			return err
+		default:
+			c.Reply.Text = fmt.Sprintf("<code>%v</code> is removed from your watchlist", ipAddr)
+		}
+		return nil
+	}
+
+	// parseTgID parses an admin's answer with a Telegram ID, an invalid value keeps the dialog going
+	parseTgID := func(c *BotContext) (int, bool) {
+		userTgID, err := strconv.Atoi(strings.TrimSpace(c.Text))
+		if err != nil {
+			c.RetryState()
+			c.Reply.Text = fmt.Sprintf("%v is invalid Telegram ID value\nShould be unsigned integer", c.Text) + dialogCancelHint
+			return 0, false
+		}
+		return userTgID, true
+	}
 
-		case false:
-			// Update keyboard
-			tgbotapi.NewRemoveKeyboard(true)
-			msg.ReplyMarkup = getUserKeyboard()
+	// setAdminStatus handles answers to the "Add new admin" and "Remove admin" prompts
+	setAdminStatus := func(isAdmin bool) BotHandlerFunc {
+		return func(c *BotContext) error {
+			userTgID, ok := parseTgID(c)
+			if !ok {
+				return nil
+			}
+			err := env.users.SetAdminStatus(userTgID, isAdmin)
 			switch {
-			case update.Message != nil && update.Message.IsCommand():
-				switch update.Message.Command() {
+			case errors.Is(err, ErrUserNotFound):
+				c.RetryState()
+				c.Reply.Text = fmt.Sprintf("User with Telegram ID %v not found", userTgID) + dialogCancelHint
+				return nil
+			case err != nil:
+				return err
+			}
+			c.Reply.Text = "Success"
+			return nil
+		}
+	}
+
+	// Watched IPs are re-checked in the background, the changes are sent to their owners
+	watchInterval := getEnvDuration("WATCH_INTERVAL", time.Hour)
+	go runWatchlist(ctx, env, watchInterval, func(tgID int, text string) {
+		notifyMsg := tgbotapi.NewMessage(int64(tgID), text)
+		notifyMsg.ParseMode = "html"
+		sendSafe(notifyMsg)
+	})
+
+	router := NewBotRouter(bot, sendSafe, env.dialogs, getEnvDuration("DIALOG_TTL", 10*time.Minute))
+	router.Use(logMiddleware, recoverMiddleware, userMiddleware(env))
+	go runDialogPurge(ctx, env.dialogs, time.Minute)
+	router.Fallback = func(c *BotContext) error {
+		c.Reply.Text = "Use the keyboard for actions."
+		return nil
+	}
+
+	router.Command("start", RoleUser, func(c *BotContext) error {
+		c.Reply.Text = "Hi. Use the keyboard for actions."
+		return nil
+	})
+	router.Command("cancel", RoleUser, func(c *BotContext) error {
+		cancelled, err := c.CancelDialog()
+		if err != nil {
+			return err
+		}
+		c.Reply.Text = "Nothing to cancel"
+		if cancelled {
+			c.Reply.Text = "Cancelled"
+		}
+		return nil
+	})
+
+	router.Button("Check IP", RoleUser, func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		return c.StartDialog(dialogStateCheckIP, "Check IP\n"+
+			"Send me IP address, subnet, hostname or URL what you want to check\n"+
+			"Send several of them separated by spaces, commas or new lines to check them at once\n"+
+			"Examples: <pre>8.8.8.8</pre><pre>192.0.2.0/24</pre><pre>example.com</pre><pre>https://example.com/path</pre>")
+	})
+	router.State(dialogStateCheckIP, RoleUser, replyCheckIP)
+
+	router.Button("Watch IP", RoleUser, func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		return c.StartDialog(dialogStateWatchIP, "Watch IP\n"+
+			"Send me IP address what you want to watch\n"+
+			fmt.Sprintf("It will be checked every %v and you will be notified about the changes", watchInterval))
+	})
+	router.State(dialogStateWatchIP, RoleUser, replyWatchIP)
+
+	router.Button("My watchlist", RoleUser, func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		watchedIPs, err := env.watchedIPs.ListByTgID(c.User.TgID)
+		if err != nil {
+			return err
+		}
+		if len(watchedIPs) == 0 {
+			c.Reply.Text = "Your watchlist is empty\nUse \"Watch IP\" to add an address"
+			return nil
+		}
+		prompt := "Watchlist\n" +
+			"Send me IP address or press its button to stop watching it\n"
+		buttons := make([][]tgbotapi.InlineKeyboardButton, 0, len(watchedIPs))
+		for _, watchedIP := range watchedIPs {
+			prompt += "\n<code>" + watchedIP.IP + "</code>"
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Stop watching "+watchedIP.IP, "unwatch:"+watchedIP.IP),
+			))
+		}
+		c.Reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons...)
+		return c.StartDialog(dialogStateUnwatchIP, prompt+"\n")
+	})
+	router.State(dialogStateUnwatchIP, RoleUser, replyUnwatchIP)
+	router.Callback("unwatch", RoleUser, func(c *BotContext) error {
+		// The button answers the "My watchlist" dialog, a later message must not be taken as another answer
+		if _, err := c.CancelDialog(); err != nil {
+			return err
+		}
+		return replyUnwatchIP(c)
+	})
+
+	// Inline queries get the class and the geolocation only, cached if possible.
+	// They aren't stored in the check history
+	inlineLookup := &EnrichmentPipeline{}
+	inlineLookup.Add(&ClassEnricher{}, time.Second)
+	inlineLookup.Add(&GeoEnricher{Provider: env.geo}, inlineQueryTimeout)
+
+	router.Update(UpdateInlineQuery, RoleUser, func(c *BotContext) error {
+		inline := tgbotapi.InlineConfig{
+			InlineQueryID: c.Update.InlineQuery.ID,
+			Results:       []interface{}{},
+			IsPersonal:    true,
+		}
+
+		// Inline mode checks single IP addresses only, other queries get no results
+		if ipAddr := net.ParseIP(strings.TrimSpace(c.Text)); ipAddr != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), inlineQueryTimeout)
+			defer cancel()
+			ipInfo, errs := inlineLookup.Run(ctx, ipAddr)
+			for name, err := range errs {
+				log.Error(name, ": ", err)
+			}
+
+			article := tgbotapi.NewInlineQueryResultArticle(ipInfo.IP, ipInfo.IP, "")
+			article.InputMessageContent = tgbotapi.InputTextMessageContent{
+				Text:      ipInfo.MessageString(),
+				ParseMode: "html",
+			}
+			article.Description = strings.TrimSpace(ipInfo.City + " " + ipInfo.CountryName)
+			inline.Results = append(inline.Results, article)
+		}
+
+		_, err := bot.AnswerInlineQuery(inline)
+		return err
+	})
+
+	router.Update(UpdateEditedMessage, RoleUser, func(c *BotContext) error {
+		c.Reply.Text = "Edited messages aren't handled\nSend a new message instead"
+		return nil
+	})
+
+	// The bot doesn't act in channels, their posts are skipped
+	ignoreChannelPost := func(c *BotContext) error {
+		return nil
+	}
+	router.Update(UpdateChannelPost, RoleAny, ignoreChannelPost)
+	router.Update(UpdateEditedChannelPost, RoleAny, ignoreChannelPost)
+
+	router.Button("Toggle location pin", RoleUser, func(c *BotContext) error {
+		if err := env.users.SetSendLocation(c.User.TgID, !c.User.SendLocation); err != nil {
+			return err
+		}
+		if c.User.SendLocation {
+			c.Reply.Text = "Location pins are turned off"
+		} else {
+			c.Reply.Text = "Location pins are turned on\nThe approximate location will be sent after each check"
+		}
+		return nil
+	})
+
+	router.Button("Get list of checked IPs", RoleUser, func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		c.Reply.Text = "Checked IPs:"
+		ipChecks, err := env.ipChecks.ListByTgID(c.User.TgID, true)
+		if err != nil {
+			log.Error(err)
+		}
+		for _, ipCheck := range ipChecks {
+			c.Reply.Text += "\n" + ipCheck.IP
+		}
+		return nil
+	})
+
+	router.Button("Get list of checked IPs results", RoleUser, func(c *BotContext) error {
+		ipChecks, err := env.ipChecks.ListByTgID(c.User.TgID, true)
+		if err != nil {
+			log.Error(err)
+		}
+		for _, ipCheck := range ipChecks {
+			ipInfo := IPInfo{}
+			byteIPInfo, err := ipCheck.IPInfo.MarshalJSON()
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			err = json.Unmarshal(byteIPInfo, &ipInfo)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			ipCheckMsg := tgbotapi.NewMessage(c.ChatID, ipInfo.MessageString())
+			ipCheckMsg.ParseMode = "html"
+			_, err = bot.Send(ipCheckMsg)
+			if err != nil {
+				log.Error(err)
+			}
+		}
+		return nil
+	})
+
+	router.Command("geo_health", RoleAdmin, func(c *BotContext) error {
+		c.Reply.Text = "Geo providers:"
+		for _, health := range geoProviderHealth(env.geo) {
+			status := "ok"
+			if !health.Healthy {
+				status = fmt.Sprintf("down until %v", health.OpenUntil.Format(time.RFC3339))
+			}
+			c.Reply.Text += fmt.Sprintf("\n%v: %v, failures: %v", health.Name, status, health.Failures)
+			if health.LastError != "" {
+				c.Reply.Text += ", last error: " + health.LastError
+			}
+		}
+		return nil
+	})
+
+	router.Command("reload_geo_db", RoleAdmin, func(c *BotContext) error {
+		err := reloadGeoProvider(env.geo)
+		switch {
+		case errors.Is(err, ErrReloadNotSupported):
+			c.Reply.Text = fmt.Sprintf("Geo provider %v doesn't support reloading", env.geo.Name())
+		case err != nil:
+			log.Error(err)
+			c.Reply.Text = "Geo database reload failed: " + err.Error()
+		default:
+			c.Reply.Text = "Geo database reloaded"
+		}
+		return nil
+	})
+
+	router.Button("Send broadcast message", RoleAdmin, func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		return c.StartDialog(dialogStateBroadcast, "Send broadcast message\n"+
+			"Send me broadcast message text")
+	})
+	router.State(dialogStateBroadcast, RoleAdmin, func(c *BotContext) error {
+		recipients, err := env.users.List()
+		if err != nil {
+			return err
+		}
+		for _, recipient := range recipients {
+			broadcastMsg := tgbotapi.NewMessage(int64(recipient.TgID), "")
+			broadcastMsg.ParseMode = "html"
+			broadcastMsg.Text = c.Text
+			sendSafe(broadcastMsg)
+		}
+		return nil
+	})
+
+	router.Button("Get list of user's checked IPs", RoleAdmin, func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		return c.StartDialog(dialogStateUserChecks, "Get list of user's checked IPs\n"+
+			"Send me user Telegram ID")
+	})
+	router.State(dialogStateUserChecks, RoleAdmin, func(c *BotContext) error {
+		userTgID, ok := parseTgID(c)
+		if !ok {
+			return nil
+		}
+		ipChecks, err := env.ipChecks.ListByTgID(userTgID, true)
+		if err != nil {
+			return err
+		}
+		c.Reply.ParseMode = "html"
+		c.Reply.Text = "Checked IPs:"
+		for _, ipCheck := range ipChecks {
+			c.Reply.Text += "\n" + ipCheck.IP
+		}
+		return nil
+	})
+
+	router.Button("Add new admin", RoleAdmin, func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		return c.StartDialog(dialogStateAddAdmin, "Add new admin\n"+
+			"Send me new admin Telegram ID\n"+
+			"NB: new admin should have a dialogue with me!")
+	})
+	router.State(dialogStateAddAdmin, RoleAdmin, setAdminStatus(true))
+
+	router.Button("Remove admin", RoleAdmin, func(c *BotContext) error {
+		c.Reply.ParseMode = "html"
+		return c.StartDialog(dialogStateRemoveAdmin, "Remove admin\n"+
+			"Send me deprecated admin Telegram ID")
+	})
+	router.State(dialogStateRemoveAdmin, RoleAdmin, setAdminStatus(false))
+
+	fmt.Printf("Authorized on account %s", bot.Self.UserName)
+
+	var updates tgbotapi.UpdatesChannel
+	if env.webhook != nil {
+		err := setWebhook(bot, env.webhook, os.Getenv("WEBHOOK_URL"), os.Getenv("WEBHOOK_CERT"), os.Getenv("WEBHOOK_MAX_CONNECTIONS"))
+		if err != nil {
+			log.Fatal("Error setting webhook: ", err)
+		}
+		updates = env.webhook.Updates
+	} else {
+		// Long polling doesn't work while a webhook is set, e.g. after the webhook mode wasn't stopped properly
+		if err := deleteWebhook(bot); err != nil {
+			log.Error(err)
+		}
+
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = 60
+
+		updates, err = bot.GetUpdatesChan(u)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		// Optional: wait for updates and clear them if you don't want to handle
+		// a large backlog of old messages
+		time.Sleep(time.Millisecond * 500)
+		updates.Clear()
+	}
+
+	botWorkers, err := strconv.Atoi(os.Getenv("BOT_WORKERS"))
+	if err != nil {
+		botWorkers = defaultBotWorkers
+	}
+	dispatcher := NewUpdateDispatcher(router.Handle, botWorkers)
 
-				case "start":
-					msg.Text = "Hi. Use the keyboard for actions."
+	for {
+		select {
+		case update := <-updates:
+			dispatcher.Dispatch(update)
 
-				default:
-					msg.Text = "I don't know that command"
+		case <-ctx.Done():
+			if env.webhook != nil {
+				// Updates coming until the API server stops get 503 and are redelivered after the restart
+				env.webhook.Close()
+				if err := deleteWebhook(bot); err != nil {
+					log.Error(err)
 				}
-			case update.Message != nil && update.Message.ReplyToMessage == nil:
-				switch update.Message.Text {
-				case "Check IP":
-					msg.ParseMode = "html"
-					msg.Text = "Check IP\n" +
-						"Reply to this message with IP address what you want to check\n" +
-						"Examples: <pre>8.8.8.8</pre>"
-
-				case "Get list of checked IPs":
-					msg.ParseMode = "html"
-					msg.Text = "Checked IPs:"
-					ipChecks, err := env.ipChecks.ListByTgID(user.TgID, true)
-					if err != nil {
-						log.Error(err)
-					}
-					for _, ipCheck := range ipChecks {
-						msg.Text += "\n" + ipCheck.IP
-					}
-
-				case "Get list of checked IPs results":
-					ipChecks, err := env.ipChecks.ListByTgID(user.TgID, true)
-					if err != nil {
-						log.Error(err)
-					}
-					for _, ipCheck := range ipChecks {
-						ipInfo := IPInfo{}
-						byteIPInfo, err := ipCheck.IPInfo.MarshalJSON()
-						if err != nil {
-							log.Error(err)
-							continue
-						}
-						err = json.Unmarshal(byteIPInfo, &ipInfo)
-						if err != nil {
-							log.Error(err)
-							continue
-						}
-						ipCheckMsg := tgbotapi.NewMessage(update.Message.Chat.ID, ipInfo.MessageString())
-						ipCheckMsg.ParseMode = "html"
-						_, err = bot.Send(ipCheckMsg)
-						if err != nil {
-							log.Error(err)
-						}
-					}
+			} else {
+				bot.StopReceivingUpdates()
+			}
+			// Updates already received are handled too
+			for len(updates) > 0 {
+				dispatcher.Dispatch(<-updates)
+			}
+			drainCtx, cancel := context.WithTimeout(context.Background(), getEnvDuration("BOT_DRAIN_TIMEOUT", 30*time.Second))
+			defer cancel()
+			if err := dispatcher.Drain(drainCtx); err != nil {
+				log.Error("Bot updates aren't drained: ", err)
+			}
+			return
+		}
+	}
+}
+
+// userMiddleware creates or updates the sender in DB and picks the keyboard for the user's role
+func userMiddleware(env *Env) BotMiddleware {
+	return func(next BotHandlerFunc) BotHandlerFunc {
+		return func(c *BotContext) error {
+			// Channel posts have no sender
+			if c.From == nil {
+				return next(c)
+			}
+
+			// Check is user in DB
+			user, err := env.users.Get(c.From.ID)
+			switch {
+			case errors.Is(err, ErrUserNotFound):
+				// If not exist -> create
+				user = getNewUser(c.From)
+				if err := env.users.Insert(user); err != nil {
+					return err
 				}
-			case update.Message != nil && update.Message.ReplyToMessage != nil && update.Message.ReplyToMessage.From.ID == bot.Self.ID:
-				switch strings.FieldsFunc(update.Message.ReplyToMessage.Text, func(r rune) bool { return r == '\n' })[0] {
-				case "Check IP":
-					msg.ParseMode = "html"
-					ipAddr := net.ParseIP(update.Message.Text)
-					if ipAddr.String() != "<nil>" {
-						ipInfo, err := getIPInfo(ipAddr)
-						if err != nil {
-							log.Error(err)
-						} else {
-							err = env.ipChecks.Insert(&IPCheck{IP: ipAddr.String(), IPInfo: ipInfo.JSONBytes(), UserTgID: user.TgID})
-							if err != nil {
-								log.Error(err)
-							}
-							msg.Text = ipInfo.MessageString()
-						}
-					} else {
-						msg.Text = "Check IP" +
-							fmt.Sprintf("\n\n<code>%v</code> is not a valid textual representation of an IP address!\n", update.Message.Text) +
-							"Try again"
-					}
+
+			case err != nil:
+				return err
+
+			default:
+				// If exist -> update
+				if err := env.users.UpdateInfo(user, getNewUser(c.From)); err != nil {
+					return err
 				}
 			}
+
+			c.User = user
+			if user.IsAdmin {
+				c.Reply.ReplyMarkup = getAdminKeyboard()
+			} else {
+				c.Reply.ReplyMarkup = getUserKeyboard()
+			}
+			return next(c)
 		}
+	}
+}
 
-		if msg.Text != "" {
-			msg.ReplyToMessageID = update.Message.MessageID
-			sendSafe(msg)
+// runDialogPurge deletes expired dialog states every interval, Get skips them, but abandoned
+// dialogs would stay in DB forever
+func runDialogPurge(ctx context.Context, dialogs interface{ DeleteExpired() error }, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := dialogs.DeleteExpired(); err != nil {
+				log.Error(err)
+			}
 		}
 	}
 }
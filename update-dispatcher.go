@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	defaultBotWorkers = 16
+	// pendingPerWorker bounds the updates dispatched but not handled yet
+	pendingPerWorker = 4
+)
+
+// UpdateDispatcher handles updates of different chats concurrently, while updates of one chat
+// are handled one by one in the order they came. Dispatch blocks while too many updates are pending
+type UpdateDispatcher struct {
+	Handle func(update tgbotapi.Update)
+
+	mu sync.Mutex
+	// queues holds the pending updates of the chats being handled
+	queues  map[int64][]tgbotapi.Update
+	workers chan struct{}
+	pending chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewUpdateDispatcher(handle func(update tgbotapi.Update), workers int) *UpdateDispatcher {
+	if workers <= 0 {
+		workers = defaultBotWorkers
+	}
+	return &UpdateDispatcher{
+		Handle:  handle,
+		queues:  make(map[int64][]tgbotapi.Update),
+		workers: make(chan struct{}, workers),
+		pending: make(chan struct{}, workers*pendingPerWorker),
+	}
+}
+
+func (d *UpdateDispatcher) Dispatch(update tgbotapi.Update) {
+	chatID := updateChatID(update)
+	d.pending <- struct{}{}
+
+	d.mu.Lock()
+	if queue, ok := d.queues[chatID]; ok {
+		d.queues[chatID] = append(queue, update)
+		d.mu.Unlock()
+		return
+	}
+	d.queues[chatID] = nil
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go d.run(chatID, update)
+}
+
+// run handles the update and then the chat updates queued meanwhile
+func (d *UpdateDispatcher) run(chatID int64, update tgbotapi.Update) {
+	defer d.wg.Done()
+	for {
+		d.workers <- struct{}{}
+		d.Handle(update)
+		<-d.workers
+		<-d.pending
+
+		d.mu.Lock()
+		queue := d.queues[chatID]
+		if len(queue) == 0 {
+			delete(d.queues, chatID)
+			d.mu.Unlock()
+			return
+		}
+		update, d.queues[chatID] = queue[0], queue[1:]
+		d.mu.Unlock()
+	}
+}
+
+// Drain waits until the dispatched updates are handled, or the context is done
+func (d *UpdateDispatcher) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// updateChatID returns the chat the update belongs to, updates without a chat are ordered by their sender
+func updateChatID(update tgbotapi.Update) int64 {
+	for _, message := range []*tgbotapi.Message{update.Message, update.EditedMessage, update.ChannelPost, update.EditedChannelPost} {
+		if message != nil && message.Chat != nil {
+			return message.Chat.ID
+		}
+	}
+	switch {
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return int64(update.CallbackQuery.From.ID)
+	case update.InlineQuery != nil && update.InlineQuery.From != nil:
+		return int64(update.InlineQuery.From.ID)
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func chatUpdate(updateID int, chatID int64) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message:  &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+	}
+}
+
+func TestUpdateDispatcherOrder(t *testing.T) {
+	mu := sync.Mutex{}
+	handled := make(map[int64][]int)
+	d := NewUpdateDispatcher(func(update tgbotapi.Update) {
+		mu.Lock()
+		defer mu.Unlock()
+		chatID := update.Message.Chat.ID
+		handled[chatID] = append(handled[chatID], update.UpdateID)
+	}, 2)
+
+	for i := 0; i < 20; i++ {
+		d.Dispatch(chatUpdate(i, int64(i%3)))
+	}
+	if err := d.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for chatID, ids := range handled {
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Errorf("chat %v updates handled out of order: %v", chatID, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestUpdateDispatcherBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	d := NewUpdateDispatcher(func(update tgbotapi.Update) {
+		<-release
+	}, 1)
+
+	for i := 0; i < pendingPerWorker; i++ {
+		d.Dispatch(chatUpdate(i, 1))
+	}
+
+	dispatched := make(chan struct{})
+	go func() {
+		d.Dispatch(chatUpdate(pendingPerWorker, 1))
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+		t.Fatal("Dispatch() should block while the pending updates are at the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-dispatched
+	if err := d.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+const maxWatchedIPs = 50
+
+// runWatchlist re-checks watched IPs every interval and calls notify with the changes
+func runWatchlist(ctx context.Context, env *Env, interval time.Duration, notify func(tgID int, text string)) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		watchedIPs, err := env.watchedIPs.ListDue(time.Now().Add(-interval))
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, watchedIP := range watchedIPs {
+			recheckWatchedIP(ctx, env, &watchedIP, notify)
+		}
+	}
+}
+
+func recheckWatchedIP(ctx context.Context, env *Env, watchedIP *WatchedIP, notify func(tgID int, text string)) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	// Fields of failed steps aren't diffed, their last good values are kept as the baseline
+	ipInfo, _ := env.enrich.Run(ctx, net.ParseIP(watchedIP.IP))
+
+	// An unreadable baseline can't be compared, the new result replaces it
+	oldInfo := IPInfo{}
+	if err := json.Unmarshal(watchedIP.IPInfo, &oldInfo); err != nil {
+		log.Error(err)
+	} else {
+		if changes := diffIPInfo(&oldInfo, ipInfo); len(changes) > 0 {
+			notify(watchedIP.UserTgID, "<b>Watched IP "+watchedIP.IP+" has changed</b>\n"+diffMessageString(changes))
+		}
+		keepFailedSections(&oldInfo, ipInfo)
+	}
+
+	if err := env.watchedIPs.UpdateInfo(watchedIP.ID, ipInfo.JSONBytes()); err != nil {
+		log.Error(err)
+	}
+}
+
+// keepFailedSections copies the fields of the steps which failed in ipInfo from old, unless
+// they failed in old too. Otherwise a change made while a lookup was failing would be missed
+func keepFailedSections(old, ipInfo *IPInfo) {
+	for name := range ipInfo.Errors {
+		if old.Errors[name] != "" {
+			continue
+		}
+		switch name {
+		case "class":
+			ipInfo.Class, ipInfo.ClassDescription = old.Class, old.ClassDescription
+		case "geo":
+			ipInfo.setGeo(old)
+		case "asn":
+			ipInfo.ASN, ipInfo.ASOrg, ipInfo.Prefix = old.ASN, old.ASOrg, old.Prefix
+		case "rdns":
+			ipInfo.Hostnames = old.Hostnames
+		case "blocklists":
+			ipInfo.Blocklists = old.Blocklists
+		case "cloud":
+			ipInfo.Cloud = old.Cloud
+		case "dnsbl":
+			ipInfo.DNSBL = old.DNSBL
+		case "rdap":
+			ipInfo.Owner = old.Owner
+		}
+		delete(ipInfo.Errors, name)
+	}
+	if len(ipInfo.Errors) == 0 {
+		ipInfo.Errors = nil
+	}
+}
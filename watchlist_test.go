@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeepFailedSections(t *testing.T) {
+	old := &IPInfo{
+		IP:          "192.0.2.1",
+		CountryName: "Netherlands",
+		City:        "Amsterdam",
+		ASN:         64500,
+		ASOrg:       "EXAMPLE",
+		Hostnames:   []string{"old.example.org"},
+		Errors:      map[string]string{"rdap": "timeout"},
+	}
+	ipInfo := &IPInfo{
+		IP:        "192.0.2.1",
+		ASN:       64501,
+		ASOrg:     "OTHER",
+		Hostnames: []string{"new.example.org"},
+		Errors:    map[string]string{"geo": "quota reached", "rdap": "timeout"},
+	}
+
+	keepFailedSections(old, ipInfo)
+
+	want := &IPInfo{
+		IP:          "192.0.2.1",
+		CountryName: "Netherlands",
+		City:        "Amsterdam",
+		ASN:         64501,
+		ASOrg:       "OTHER",
+		Hostnames:   []string{"new.example.org"},
+		// The step failed in both checks, there is nothing to keep
+		Errors: map[string]string{"rdap": "timeout"},
+	}
+	if !reflect.DeepEqual(ipInfo, want) {
+		t.Errorf("keepFailedSections() = %+v, want %+v", ipInfo, want)
+	}
+}
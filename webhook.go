@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	webhookPath           = "/tg_webhook/"
+	webhookSecretHeader   = "X-Telegram-Bot-Api-Secret-Token"
+	webhookEnqueueTimeout = 5 * time.Second
+)
+
+var (
+	ErrInvalidWebhookSecret = errors.New("webhook secret must be 1-256 characters A-Z, a-z, 0-9, _ or -")
+	ErrWebhookBusy          = errors.New("bot is busy, retry later")
+
+	webhookSecretRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+)
+
+// WebhookHandler receives bot updates sent by Telegram. The secret is both the last segment
+// of the webhook path and the token Telegram sends in the header.
+// Updates is unbuffered, an update is accepted only once the bot has taken it
+type WebhookHandler struct {
+	Secret  string
+	Updates chan tgbotapi.Update
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewWebhookHandler(secret string) (*WebhookHandler, error) {
+	if !webhookSecretRegexp.MatchString(secret) {
+		return nil, ErrInvalidWebhookSecret
+	}
+	return &WebhookHandler{
+		Secret:  secret,
+		Updates: make(chan tgbotapi.Update),
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+// Close makes the handler refuse updates, the bot calls it when it stops taking them
+func (wh *WebhookHandler) Close() {
+	wh.closeOnce.Do(func() {
+		close(wh.closed)
+	})
+}
+
+func (wh *WebhookHandler) Path() string {
+	return webhookPath + wh.Secret
+}
+
+func (wh *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(webhookSecretHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(wh.Secret)) != 1 {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	update := tgbotapi.Update{}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		badResp, _ := NewErrorResponse(err).toJSON()
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write(badResp); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	// Telegram redelivers the update if it isn't accepted. Nobody takes updates after Close,
+	// so an accepted update is always handled
+	select {
+	case wh.Updates <- update:
+		w.WriteHeader(http.StatusOK)
+		return
+	case <-wh.closed:
+	case <-time.After(webhookEnqueueTimeout):
+	}
+	badResp, _ := NewErrorResponse(ErrWebhookBusy).toJSON()
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if _, err := w.Write(badResp); err != nil {
+		log.Error(err)
+	}
+}
+
+// setWebhook points Telegram to the handler at baseURL, certPath is the public key
+// of a self-signed certificate, if the server uses one
+func setWebhook(bot *tgbotapi.BotAPI, wh *WebhookHandler, baseURL string, certPath string, maxConnections string) error {
+	webhookURL := strings.TrimSuffix(baseURL, "/") + wh.Path()
+	if _, err := url.ParseRequestURI(webhookURL); err != nil {
+		return err
+	}
+
+	params := map[string]string{
+		"url":          webhookURL,
+		"secret_token": wh.Secret,
+	}
+	if maxConnections != "" {
+		params["max_connections"] = maxConnections
+	}
+	if certPath != "" {
+		_, err := bot.UploadFile("setWebhook", params, "certificate", certPath)
+		return err
+	}
+
+	values := url.Values{}
+	for key, value := range params {
+		values.Set(key, value)
+	}
+	_, err := bot.MakeRequest("setWebhook", values)
+	return err
+}
+
+func deleteWebhook(bot *tgbotapi.BotAPI) error {
+	_, err := bot.MakeRequest("deleteWebhook", url.Values{})
+	return err
+}
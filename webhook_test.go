@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	wh, err := NewWebhookHandler("test_secret-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(secret string) int {
+		request := httptest.NewRequest(http.MethodPost, wh.Path(), strings.NewReader(`{"update_id": 1}`))
+		request.Header.Set(webhookSecretHeader, secret)
+		recorder := httptest.NewRecorder()
+		wh.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := post("wrong"); code != http.StatusForbidden {
+		t.Errorf("update with a wrong secret got %v, want %v", code, http.StatusForbidden)
+	}
+
+	received := make(chan int)
+	go func() {
+		update := <-wh.Updates
+		received <- update.UpdateID
+	}()
+	if code := post(wh.Secret); code != http.StatusOK {
+		t.Errorf("update got %v, want %v", code, http.StatusOK)
+	}
+	if updateID := <-received; updateID != 1 {
+		t.Errorf("received update %v, want 1", updateID)
+	}
+
+	wh.Close()
+	if code := post(wh.Secret); code != http.StatusServiceUnavailable {
+		t.Errorf("update after Close got %v, want %v", code, http.StatusServiceUnavailable)
+	}
+
+	if _, err := NewWebhookHandler("bad/secret"); err != ErrInvalidWebhookSecret {
+		t.Errorf("NewWebhookHandler() error = %v, want %v", err, ErrInvalidWebhookSecret)
+	}
+}